@@ -7,18 +7,29 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/ethdb/leveldb"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/ethdb/pebble"
 	"github.com/ethereum/go-ethereum/triedb"
+	"github.com/ethereum/go-ethereum/triedb/pathdb"
 	"github.com/holiman/uint256"
 )
 
+// pathDefaults is the path scheme counterpart of triedb.HashDefaults.
+var pathDefaults = &triedb.Config{PathDB: pathdb.Defaults}
+
 func main() {
 	var (
 		nAccounts = flag.Int("n", 100, "Number of accounts to create")
@@ -26,96 +37,782 @@ func main() {
 		mModify   = flag.Int("m", 10, "Number of accounts to modify after creation")
 		kCommit   = flag.Int("k", 50, "Number of accounts per commit/flush")
 		dbPath    = flag.String("db", "mpt_bench_db", "Path to LevelDB")
+		backend   = flag.String("backend", "leveldb", "Key-value store backend: leveldb, pebble or memory")
+		backends  = flag.String("backends", "", "Comma-separated backends to run the same workload against one after the other, each in <db>-<backend>, and compare")
 		clearDB   = flag.Bool("clear", true, "Clear database before starting")
+		workload  = flag.String("workload", "slots", "Modification workload: slots, evm, access-list, sstore-churn, selfdestruct, system, delegation, precompile, forks, copy-cost, journal, builder or code-blobs")
+		nCreates  = flag.Int("creates", 0, "Contract deployments per block in the evm workload, alternating CREATE and CREATE2")
+		ctorSlots = flag.Int("ctor-slots", 16, "Storage slots written by the init code of each deployed contract")
+		nVariants = flag.Int("code-variants", 1, "Distinct runtime codes of varying size that deployments pick from, popular ones more often")
+		verkle    = flag.Bool("verkle", false, "Use the (experimental) verkle trie and report EIP-4762 witness gas per transaction")
+		nShards   = flag.Int("shards", 1, "Number of independent account tries sharing the database, committed separately")
+		snapReads = flag.Int("snapshot-reads", 0, "Slot reads served from a pinned state view while writes continue (0 = skip)")
+		rawKeys   = flag.Bool("raw-keys", false, "Compare the keccak-hashed secure trie against a trie keyed by raw addresses and slot indices")
+		bulkLoad  = flag.Bool("bulk-load", false, "Build the Phase 1 state with sorted StackTrie insertion, writing nodes directly")
+		direct    = flag.Bool("trie-direct", false, "Drive trie.Trie directly instead of the StateDB, on the same key/value stream")
+		hashAlts  = flag.String("hash-research", "", "Non-consensus research: comma-separated hash functions (sha256, blake2b, keccak160, poseidon2) to rehash the final trie nodes with, estimating time and trie size against keccak256 (rehash estimate, the trie hasher itself is not swapped)")
+		rootCache = flag.Bool("root-cache", false, "With -trie-direct, keep every account with its latest storage root cached across batches instead of looking it up in the account trie")
+		hashCost  = flag.Bool("hash-cost", false, "With -trie-direct, report keccak time (key derivation, key and node hashing) apart from trie manipulation and database writes")
+		seed      = flag.Int64("seed", 0, "Random seed of the modification workload (0 = time-based)")
+		nForks    = flag.Int("forks", 64, "StateDB copies per round in the forks workload, all but one discarded")
+		jWrites   = flag.Int("journal-writes", 1000, "Writes between two snapshots in the journal workload")
+		nCands    = flag.Int("candidates", 16, "Largest number of candidate payloads per slot in the builder workload")
+		flat      = flag.Bool("flat", false, "Replay the slots workload against a flat plain-key state store and compare it to the MPT run")
+		scheme    = flag.String("scheme", "hash", "Trie node storage scheme: hash or path")
+		separate  = flag.Bool("separate", false, "Store code, account trie nodes, storage trie nodes and preimages in separate LevelDB instances")
+		freezeOld = flag.Bool("freeze-old", false, "Move trie nodes only reachable from old roots into a cold append-only store and measure historical reads")
+		startBlk  = flag.Uint64("start-block", 0, "First block number of the modification phase (0 = right after the last committed block)")
+		blockTime = flag.Uint64("block-time", 12, "Seconds between the timestamps of consecutive simulated blocks")
+		sdbMode   = flag.String("statedb", "recreate", "StateDB lifetime across batches: recreate (commit and reopen per batch), reuse (one instance per phase) or unbounded (one instance for the whole run, never hashed or collected)")
+		memCap    = flag.Int("mem-cap", 0, "Abort the run once the heap exceeds this many MB (0 = no cap)")
+		compact   = flag.String("compaction", "", "Compaction scheduling: load (background only), idle (pause to compact every -compact-every batches) or gaps (compact between phases); measures final read latency (empty = off)")
+		compactN  = flag.Int("compact-every", 100, "Batches between compaction pauses in idle compaction mode")
+		lightVfy  = flag.Int("light-verify", 0, "Account plus slot proofs a simulated light client verifies against the state root alone, on one and on all cores (0 = skip)")
+		multiAccs = flag.Int("multiproof", 0, "Largest number of accounts (16 slots each) proven at once in the multiproof phase, compared to per-key proofs (0 = skip)")
+		negReads  = flag.Int("negative-reads", 0, "Lookups of absent accounts and slots, with and without an in-memory existence filter in front of the trie (0 = skip)")
+		archReads = flag.Int("archive-reads", 0, "Queries of one key at randomly picked historical roots, reported by root age (0 = skip)")
+		vecDir    = flag.String("vector-dir", "mpt_vectors", "Output directory of export-vectors")
+		vecSizes  = flag.String("vector-sizes", "1,2,16,256,4096", "Comma-separated key counts of the bundles written by export-vectors")
+		crashAft  = flag.Duration("crash-after", 5*time.Second, "Run time after which crash-test kills the benchmark of each backend")
+		announce  = flag.Bool("announce-commits", false, "Print the root of every commit as it completes, as crash-test relies on")
+		vizFormat = flag.String("viz-format", "dot", "Output format of visualize: dot (Graphviz) or html (collapsible tree)")
+		vizOut    = flag.String("viz-out", "", "Output file of visualize (default trie.dot or trie.html)")
+		vizSlots  = flag.String("viz-storage", "", "Comma-separated account indices whose storage tries visualize renders too")
+		vizMax    = flag.Int("viz-max-nodes", 500, "Nodes per trie after which visualize truncates the rendering")
+		listen    = flag.String("listen", "localhost:8080", "Address the explore web UI listens on")
+		diffDir   = flag.String("state-diffs", "", "Directory to write a trace_replayBlockTransactions-style JSON state diff of every commit to (empty = off)")
+		memBudget = flag.String("memory-budget", "", "Heap budget (e.g. 8GB) the commit batch size is tuned to after every batch instead of a fixed -k (empty = fixed)")
+		entropy   = flag.String("value-entropy", "", "Slot values of the slots and evm workloads: hash (keccak output), small (small integers) or pattern (repetitive bytes), reporting their compressibility (empty = hash, unreported)")
+		traceFile = flag.String("trace-file", "", "CSV file to write a reservoir sample of individual slot write, call and commit latencies of the slots and evm workloads to (empty = off)")
+		traceSize = flag.Int("trace-samples", 100000, "Operations kept in the latency trace reservoir")
+		touchFile = flag.String("touch-file", "", "CSV file to export per-account and per-slot touch counts of the slots and evm workloads to (empty = off)")
+		churn     = flag.Bool("churn", false, "Account the bytes added, rewritten and deleted key by key in the key-value store per phase, per modified account and slot")
+		locality  = flag.Bool("locality", false, "Record the trie node reads of the modification and read phases and report their reuse distances as clean cache hit ratios by cache size")
+		clusterN  = flag.Int("cluster-reads", 0, "Random storage reads compared between copies of the final state keyed by global node hash and with storage nodes clustered under their account (0 = skip)")
+		flushIvl  = flag.Duration("flush-interval", 0, "Flush trie nodes to disk from a background goroutine at this interval instead of after every batch (0 = inline)")
+		flushSize = flag.Int("flush-size", 0, "Flush trie nodes to disk from a background goroutine once the unflushed nodes exceed this many MB (0 = no size threshold)")
+		artDir    = flag.String("artifact-dir", "", "Directory to collect the log, configuration, profiles, JSON report and output files of the run in, under a timestamped subdirectory (empty = off)")
+		artTar    = flag.Bool("artifact-tar", false, "Pack the artifact directory of the run into a .tar.gz")
+		acctUpds  = flag.Int("account-updates", 0, "Balance and nonce bumps of random existing accounts, without storage writes, committed every -k accounts after the modification phase (0 = skip)")
+		readTrace = flag.Int("read-trace", 0, "Sampled account plus slot lookups traced node by node, reporting time per trie level and the share of nodes read from disk (0 = skip)")
+		partFlag  = flag.String("shard", "", "Generate only accounts i, i+n, i+2n, ... of the state, given as i/n, so that n machines with the same seed build disjoint parts for merge (empty = whole state)")
+		mergeFrom = flag.String("merge-from", "", "Comma-separated databases built with -shard that merge combines into the one at -db")
+		snapLayrs = flag.Int("snapshot-layers", 0, "Snapshot diff layers stacked on the final state before flattening, timing reads at growing stack depths (0 = skip)")
+		sysIvl    = flag.Duration("sys-sample", 0, "Sample disk throughput and IOPS, CPU utilization and page cache size from /proc at this interval during every phase and report the series (0 = off, Linux only)")
+		queryMix  = flag.Int("query-mix", 0, "Simulated RPC calls (getBalance, getStorageAt, getProof, getCode) served concurrently from the final root, reporting the aggregate queries per second (0 = skip)")
+		queryWts  = flag.String("query-weights", "getBalance=40,getStorageAt=45,getCode=10,getProof=5", "Relative weights of the RPC calls in the query mix")
+		queryConc = flag.Int("query-concurrency", 0, "Concurrent clients issuing the query mix (0 = GOMAXPROCS)")
+		dsPath    = flag.String("dataset", "", "Stream the keys and values of the run from a file written by gen-dataset instead of generating them")
 	)
-	flag.Parse()
+	// The modify subcommand continues the state history of an existing
+	// database instead of creating a fresh state first, export-vectors
+	// writes trie test vectors and gen-dataset precomputes the keys and
+	// values of a run instead of running a benchmark. crash-test kills and
+	// recovers a run per backend, visualize renders the tries of a small one,
+	// explore serves a web UI over an existing database and merge combines
+	// the parts of a state built with -shard.
+	var command string
+	if len(os.Args) > 1 && slices.Contains([]string{"modify", "export-vectors", "gen-dataset", "crash-test", "visualize", "explore", "merge"}, os.Args[1]) {
+		command = os.Args[1]
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
+	if command == "export-vectors" {
+		if err := exportVectors(*vecDir, *vecSizes); err != nil {
+			fmt.Printf("Failed to export test vectors: %v\n", err)
+		}
+		return
+	}
+	if command == "visualize" {
+		if err := visualizeState(*backend, *dbPath, *scheme, *vizFormat, *vizOut, *vizSlots, *vizMax); err != nil {
+			fmt.Printf("Failed to visualize %s: %v\n", *dbPath, err)
+		}
+		return
+	}
+	if command == "explore" {
+		if err := exploreState(*backend, *dbPath, *scheme, *listen); err != nil {
+			fmt.Printf("Explorer failed: %v\n", err)
+		}
+		return
+	}
+	if command == "merge" {
+		if *mergeFrom == "" || *scheme != "hash" {
+			fmt.Printf("merge needs the hash-scheme part databases given by -merge-from\n")
+			return
+		}
+		if err := mergeStates(*backend, *dbPath, strings.Split(*mergeFrom, ",")); err != nil {
+			fmt.Printf("Merge failed: %v\n", err)
+		}
+		return
+	}
+	modifyOnly := command == "modify"
+	if modifyOnly {
+		*clearDB = false
+	}
+	if modifyOnly && (*nShards > 1 || *bulkLoad || *direct || *flat) {
+		fmt.Printf("modify cannot be combined with -shards, -bulk-load, -trie-direct or -flat\n")
+		return
+	}
 
+	if _, ok := workloads[*workload]; !ok && *workload != "slots" && *workload != "evm" {
+		fmt.Printf("Unknown workload %q\n", *workload)
+		return
+	}
+	seeded := *seed != 0
+	if !seeded {
+		*seed = time.Now().UnixNano()
+	}
+	if *entropy != "" && *entropy != "hash" && *entropy != "small" && *entropy != "pattern" {
+		fmt.Printf("Unknown value entropy %q\n", *entropy)
+		return
+	}
+	if *entropy != "" && (*bulkLoad || *direct || *flat || workloads[*workload] != nil) {
+		fmt.Printf("-value-entropy applies to the slots and evm workloads, without -bulk-load, -trie-direct or -flat\n")
+		return
+	}
+	if command == "gen-dataset" {
+		if *dsPath == "" {
+			fmt.Printf("gen-dataset needs an output file given by -dataset\n")
+			return
+		}
+		if err := writeDataset(*dsPath, *nAccounts, *nSlots, min(*mModify, *nAccounts), *seed, *entropy); err != nil {
+			fmt.Printf("Failed to generate dataset: %v\n", err)
+		}
+		return
+	}
+	var ds *dataset
+	if *dsPath != "" {
+		if modifyOnly || *bulkLoad || *direct || *flat || (*workload != "slots" && *workload != "evm") {
+			fmt.Printf("A dataset only feeds the slots and evm workloads, without modify, -bulk-load, -trie-direct or -flat\n")
+			return
+		}
+		var err error
+		if ds, err = openDataset(*dsPath); err != nil {
+			fmt.Printf("Failed to open dataset: %v\n", err)
+			return
+		}
+		defer ds.close()
+		*nAccounts, *nSlots, *mModify, *seed = int(ds.accounts), int(ds.slots), int(ds.modified), ds.seed
+		seeded = true
+		fmt.Printf("Streaming keys and values from %s\n", *dsPath)
+	}
+	var artifacts *artifactBundle
+	if *artDir != "" {
+		var err error
+		if artifacts, err = newArtifactBundle(*artDir, *artTar); err != nil {
+			fmt.Printf("Failed to create artifact directory: %v\n", err)
+			return
+		}
+		defer artifacts.close(*traceFile, *touchFile, *diffDir)
+	}
+	if *backend != "leveldb" && *backend != "pebble" && *backend != "memory" {
+		fmt.Printf("Unknown backend %q\n", *backend)
+		return
+	}
+	if *backend == "memory" && (modifyOnly || *separate) {
+		fmt.Printf("The memory backend cannot be combined with modify or -separate\n")
+		return
+	}
+	if command == "crash-test" {
+		list := "leveldb,pebble"
+		if *backends != "" {
+			list = *backends
+		}
+		runCrashTests(strings.Split(list, ","), *dbPath, *scheme, *seed, *crashAft)
+		return
+	}
+	if *backends != "" {
+		runBackends(strings.Split(*backends, ","), *dbPath, *seed)
+		return
+	}
+	if *nVariants < 1 {
+		fmt.Printf("Deployments need at least one code variant\n")
+		return
+	}
+	if *flat && (*nShards > 1 || *verkle || *workload != "slots" || *nCreates > 0) {
+		fmt.Printf("The flat state comparison requires a single hash-scheme shard and the slots workload, without -creates\n")
+		return
+	}
+	if *freezeOld && (*nShards > 1 || *verkle) {
+		fmt.Printf("Freezing old trie nodes requires a single hash-scheme shard\n")
+		return
+	}
+	if *sdbMode != "recreate" && *sdbMode != "reuse" && *sdbMode != "unbounded" {
+		fmt.Printf("Unknown statedb mode %q\n", *sdbMode)
+		return
+	}
+	if *sdbMode == "unbounded" && (*bulkLoad || *direct || *freezeOld || *snapReads > 0) {
+		fmt.Printf("An unbounded statedb has no intermediate roots for -bulk-load, -trie-direct, -freeze-old or -snapshot-reads\n")
+		return
+	}
+	if *compact != "" && *compact != "load" && *compact != "idle" && *compact != "gaps" {
+		fmt.Printf("Unknown compaction mode %q\n", *compact)
+		return
+	}
+	if *compact != "" && workloads[*workload] != nil {
+		fmt.Printf("Compaction scheduling applies to the slots and evm workloads only\n")
+		return
+	}
+	if *diffDir != "" && (*nShards > 1 || *verkle || *direct || *bulkLoad) {
+		fmt.Printf("State diffs require a single merkle patricia trie driven through the StateDB\n")
+		return
+	}
+	if (*traceFile != "" || *touchFile != "") && (*bulkLoad || *direct || workloads[*workload] != nil) {
+		fmt.Printf("Latency traces and touch counts cover the slots and evm workloads, without -bulk-load or -trie-direct\n")
+		return
+	}
+	if *churn && (*direct || workloads[*workload] != nil) {
+		fmt.Printf("Churn accounting covers the slots and evm workloads, without -trie-direct\n")
+		return
+	}
+	if *locality && (*scheme != "hash" || *verkle || *direct || workloads[*workload] != nil) {
+		fmt.Printf("Access locality requires the hash scheme and the slots or evm workload, without -verkle or -trie-direct\n")
+		return
+	}
+	budget, err := parseByteSize(*memBudget)
+	if err != nil {
+		fmt.Printf("Invalid memory budget: %v\n", err)
+		return
+	}
+	if budget > 0 && (*sdbMode != "recreate" || *bulkLoad || *direct || workloads[*workload] != nil) {
+		fmt.Printf("A memory budget requires -statedb recreate and the slots or evm workload, without -bulk-load or -trie-direct\n")
+		return
+	}
+	if (*negReads > 0 || *multiAccs > 0 || *lightVfy > 0) && *sdbMode == "unbounded" {
+		fmt.Printf("Negative lookups and proofs need a committed root, which -statedb unbounded does not provide\n")
+		return
+	}
+	if *readTrace > 0 && (*verkle || *nShards > 1 || *sdbMode == "unbounded") {
+		fmt.Printf("Read-path tracing requires a single committed merkle patricia trie\n")
+		return
+	}
+	weights, err := parseQueryWeights(*queryWts)
+	if err != nil {
+		fmt.Printf("Invalid query weights: %v\n", err)
+		return
+	}
+	if *queryMix > 0 && (*verkle || *nShards > 1 || *sdbMode == "unbounded") {
+		fmt.Printf("The query mix is served from a single committed merkle patricia trie\n")
+		return
+	}
+	if (*multiAccs > 0 || *lightVfy > 0) && (*verkle || *nShards > 1) {
+		fmt.Printf("The proof phases require a single merkle patricia trie\n")
+		return
+	}
+	// pathdb turns the layers below a flushed root stale, under the statedb
+	// the workload keeps reading, so background flushes need the hash scheme.
+	if (*flushIvl > 0 || *flushSize > 0) && (*sdbMode != "recreate" || *scheme != "hash" || *nShards > 1 || *verkle || *bulkLoad || *direct || workloads[*workload] != nil) {
+		fmt.Printf("Background flushing requires -statedb recreate, a single hash-scheme merkle patricia trie and the slots or evm workload, without -bulk-load or -trie-direct\n")
+		return
+	}
+	if *acctUpds > 0 && (*direct || workloads[*workload] != nil) {
+		fmt.Printf("The account update phase follows the slots and evm workloads, without -trie-direct\n")
+		return
+	}
+	part, err := parseStatePart(*partFlag)
+	if err != nil {
+		fmt.Printf("Invalid shard: %v\n", err)
+		return
+	}
+	if part.count > 1 && !seeded {
+		fmt.Printf("A state shard needs the -seed shared by all parts, a time-based one differs per machine\n")
+		return
+	}
+	if part.count > 1 && (modifyOnly || *scheme != "hash" || *nShards > 1 || *verkle || *bulkLoad || *direct || *flat || *nCreates > 0 || workloads[*workload] != nil) {
+		fmt.Printf("A state shard is built by the hash-scheme slots or evm workload, without modify, -shards, -verkle, -bulk-load, -trie-direct, -flat or -creates\n")
+		return
+	}
+	if part.count > 1 && (*snapReads > 0 || *snapLayrs > 0 || *queryMix > 0 || *freezeOld || *archReads > 0 || *negReads > 0 || *multiAccs > 0 || *lightVfy > 0 || *clusterN > 0 || *readTrace > 0 || *acctUpds > 0 || *compact != "") {
+		fmt.Printf("A state shard only runs the creation and modification phases, the read phases need the merged state\n")
+		return
+	}
+	if workloads[*workload] != nil && (*snapReads > 0 || *snapLayrs > 0 || *queryMix > 0 || *freezeOld || *archReads > 0 || *negReads > 0 || *multiAccs > 0 || *lightVfy > 0 || *clusterN > 0 || *readTrace > 0 || *rawKeys || *hashAlts != "") {
+		fmt.Printf("The %s workload ends with its own report, the read phases and final comparisons follow the slots and evm workloads only\n", *workload)
+		return
+	}
+	if *snapLayrs > 0 && (*scheme != "hash" || *verkle || *nShards > 1 || *sdbMode == "unbounded") {
+		fmt.Printf("Snapshot layers are stacked on a single committed hash-scheme merkle patricia trie\n")
+		return
+	}
+	if *clusterN > 0 && (*scheme != "hash" || *verkle || *nShards > 1 || *sdbMode == "unbounded") {
+		fmt.Printf("Storage clustering compares hash-scheme layouts of a single committed merkle patricia trie\n")
+		return
+	}
+	if *archReads > 0 && (*freezeOld || *sdbMode == "unbounded") {
+		fmt.Printf("Historical reads need every root kept in place, which -freeze-old and -statedb unbounded prevent\n")
+		return
+	}
+	if *hashAlts != "" {
+		for _, name := range strings.Split(*hashAlts, ",") {
+			if _, ok := researchHashes[name]; !ok {
+				fmt.Printf("Unknown research hash function %q\n", name)
+				return
+			}
+		}
+		if *verkle {
+			fmt.Printf("-hash-research requires the merkle patricia trie\n")
+			return
+		}
+	}
+	if (*hashCost || *rootCache) && !*direct {
+		fmt.Printf("-hash-cost and -root-cache require -trie-direct\n")
+		return
+	}
+	if *scheme != "hash" && *scheme != "path" {
+		fmt.Printf("Unknown scheme %q\n", *scheme)
+		return
+	}
+	if *scheme == "path" && (*verkle || *nShards > 1 || *bulkLoad || *direct || *freezeOld || *snapReads > 0) {
+		fmt.Printf("The path scheme cannot be combined with -verkle, -shards, -bulk-load, -trie-direct, -freeze-old or -snapshot-reads\n")
+		return
+	}
+	if *direct && (*nShards > 1 || *verkle || *bulkLoad || *workload != "slots") {
+		fmt.Printf("Direct trie mode requires a single hash-scheme shard and the slots workload\n")
+		return
+	}
+	if *bulkLoad && (*nShards > 1 || *verkle) {
+		fmt.Printf("Bulk loading requires a single hash-scheme shard\n")
+		return
+	}
+	if *nShards < 1 || (*nShards > 1 && (workloads[*workload] != nil || *snapReads > 0)) {
+		fmt.Printf("Sharding requires -shards >= 1 and the slots or evm workload without snapshot reads\n")
+		return
+	}
 	if *clearDB {
 		fmt.Printf("Cleaning up old database at %s...\n", *dbPath)
 		os.RemoveAll(*dbPath)
 	}
 
-	// 1. Initialize LevelDB
-	fmt.Printf("Initializing LevelDB at %s...\n", *dbPath)
-	ldb, err := leveldb.New(*dbPath, 256, 1024, "eth/db/chaindata/", false)
+	// 1. Initialize the key-value store
+	var (
+		kvdb   ethdb.KeyValueStore
+		router *prefixRouter
+	)
+	switch {
+	case *separate:
+		fmt.Printf("Initializing separated LevelDB instances under %s...\n", *dbPath)
+		router, err = newPrefixRouter(*dbPath)
+		kvdb = router
+	case *backend == "memory":
+		fmt.Printf("Initializing in-memory database...\n")
+		kvdb = memorydb.New()
+	case *backend == "pebble":
+		fmt.Printf("Initializing Pebble at %s...\n", *dbPath)
+		kvdb, err = openDiskStore(*backend, *dbPath)
+	default:
+		fmt.Printf("Initializing LevelDB at %s...\n", *dbPath)
+		kvdb, err = openDiskStore(*backend, *dbPath)
+	}
 	if err != nil {
-		fmt.Printf("Failed to open LevelDB: %v\n", err)
+		fmt.Printf("Failed to open database: %v\n", err)
 		return
 	}
-	diskdb := rawdb.NewDatabase(ldb)
+	var counter *countingStore
+	if *negReads > 0 || *readTrace > 0 {
+		counter = &countingStore{KeyValueStore: kvdb}
+		kvdb = counter
+	}
+	var churnDB *churnStore
+	if *churn {
+		churnDB = newChurnStore(kvdb)
+		kvdb = churnDB
+	}
+	var accesses *accessRecorder
+	if *locality {
+		accesses = newAccessRecorder(kvdb)
+		kvdb = accesses
+	}
+	diskdb := rawdb.NewDatabase(kvdb)
 	defer diskdb.Close()
 
 	// 2. Initialize TrieDB and StateDB
-	trieDB := triedb.NewDatabase(diskdb, triedb.HashDefaults)
+	config := triedb.HashDefaults
+	switch {
+	case *verkle:
+		config = triedb.VerkleDefaults
+	case *scheme == "path":
+		config = pathDefaults
+	}
+	if *separate {
+		config = &triedb.Config{Preimages: true, HashDB: config.HashDB, PathDB: config.PathDB, IsVerkle: config.IsVerkle}
+	}
+	trieDB := triedb.NewDatabase(diskdb, config)
 	sdb := state.NewDatabase(trieDB, nil)
-	statedb, _ := state.New(common.Hash{}, sdb)
+	shards := make([]*benchEnv, *nShards)
+	chain := &simChain{period: *blockTime}
+	// pathdb keys the layer of the empty state by the empty trie root, not by
+	// the zero hash the hash scheme accepts.
+	empty := common.Hash{}
+	if *scheme == "path" {
+		empty = types.EmptyRootHash
+	}
+	for s := range shards {
+		statedb, err := state.New(empty, sdb)
+		if err != nil {
+			fmt.Printf("Failed to open StateDB: %v\n", err)
+			return
+		}
+		shards[s] = &benchEnv{trieDB: trieDB, sdb: sdb, statedb: statedb, reuse: *sdbMode != "recreate", unbounded: *sdbMode == "unbounded", announce: *announce, chain: chain}
+	}
+	env := shards[0]
+	if *flushIvl > 0 || *flushSize > 0 {
+		env.flusher = newBackgroundFlusher(trieDB, *flushIvl, *flushSize, *announce)
+	}
+	if *diffDir != "" {
+		if err := os.MkdirAll(*diffDir, 0755); err != nil {
+			fmt.Printf("Failed to create state diff directory: %v\n", err)
+			return
+		}
+		env.diffs = newStateDiffWriter(*diffDir, trieDB, *nAccounts, *nSlots)
+	}
+	var (
+		commits   shardCommits
+		growth    = &growthCurve{path: *dbPath}
+		ops       = &heapCurve{limit: *memCap}
+		compactor = &compactionScheduler{db: kvdb, mode: *compact, every: *compactN, begin: time.Now()}
+	)
+
+	if *direct {
+		if *mModify > *nAccounts {
+			*mModify = *nAccounts
+		}
+		fmt.Printf("Driving trie.Trie directly: %d accounts, %d slots each, %d modified (k=%d, seed=%d)...\n", *nAccounts, *nSlots, *mModify, *kCommit, *seed)
+		dt := &directTrie{trieDB: trieDB}
+		if *hashCost {
+			dt.costs = new(hashCosts)
+		}
+		if *rootCache {
+			dt.rootCache = make(map[common.Address]*types.StateAccount)
+		}
+		if err := dt.run(*nAccounts, *nSlots, *mModify, *kCommit, rand.New(rand.NewSource(*seed))); err != nil {
+			fmt.Printf("Direct trie run failed: %v\n", err)
+			return
+		}
+		size := getDirSize(*dbPath)
+		fmt.Printf("\n--- Final Report ---\n")
+		fmt.Printf("Database Path: %s\n", *dbPath)
+		fmt.Printf("Disk Usage:    %.2f MB\n", float64(size)/(1024*1024))
+		fmt.Printf("Creation:      %v\n", dt.createTime)
+		fmt.Printf("Modification:  %v\n", dt.modifyTime)
+		fmt.Printf("Final Root:    %x\n", dt.root)
+		fmt.Printf("Acc. Lookups:  %d in the account trie (%v), %d from the root cache during modification\n",
+			dt.lookups, dt.lookupTime.Round(time.Microsecond), dt.cacheHits)
+		if dt.costs != nil {
+			dt.costs.report()
+		}
+		return
+	}
 
 	// 3. Phase 1: Creation
-	fmt.Printf("Phase 1: Creating %d accounts with %d slots each (k=%d)...\n", *nAccounts, *nSlots, *kCommit)
+	var system *sysSampler
+	if *sysIvl > 0 {
+		if system, err = newSysSampler(*sysIvl); err != nil {
+			fmt.Printf("Failed to sample system resources: %v\n", err)
+			return
+		}
+	}
+	var head *benchHead
+	if modifyOnly {
+		if head = readBenchHead(diskdb); head == nil {
+			fmt.Printf("No benchmark state found in %s, run a full benchmark first\n", *dbPath)
+			return
+		}
+		*nAccounts, *nSlots = int(head.Accounts), int(head.Slots)
+		fmt.Printf("Phase 1: Skipped, continuing from root %x at block %d (%d accounts, %d slots each)\n", head.Root, head.Block, *nAccounts, *nSlots)
+	} else {
+		fmt.Printf("Phase 1: Creating %d accounts with %d slots each (k=%d)...\n", *nAccounts, *nSlots, *kCommit)
+		system.begin("Creation")
+		if part.count > 1 {
+			fmt.Printf("Building shard %d/%d: every %d. account from account %d\n", part.index, part.count, part.count, part.index)
+		}
+	}
 	start := time.Now()
 
 	addrs := make([]common.Address, *nAccounts)
 	batchSize := *kCommit
-	var currentRoot common.Hash
-
-	for i := 0; i < *nAccounts; i++ {
-		addr := common.BytesToAddress(crypto.Keccak256([]byte(fmt.Sprintf("account-%d", i)))[:20])
-		addrs[i] = addr
-
-		statedb.SetBalance(addr, uint256.NewInt(1e18), tracing.BalanceChangeUnspecified)
-		statedb.SetNonce(addr, uint64(i), tracing.NonceChangeUnspecified)
+	var (
+		createBatches = newBatchTuner(batchSize, budget)
+		modifyBatches = newBatchTuner(batchSize, budget)
+		trace         *latencyTrace
+		touches       *touchCounter
+	)
+	if *traceFile != "" {
+		trace = newLatencyTrace(*traceSize, *seed)
+	}
+	if *touchFile != "" {
+		touches = newTouchCounter()
+	}
 
-		for j := 0; j < *nSlots; j++ {
-			slotKey := common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("slot-%d", j))))
-			slotVal := common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("value-%d", j))))
-			statedb.SetState(addr, slotKey, slotVal)
+	if head != nil {
+		for i := range addrs {
+			addrs[i] = common.BytesToAddress(crypto.Keccak256([]byte(fmt.Sprintf("account-%d", i)))[:20])
 		}
-
-		if (i+1)%10 == 0 || i+1 == *nAccounts {
-			fmt.Printf("...processed %d/%d accounts (%.1f%%)\r", i+1, *nAccounts, float64(i+1)/float64(*nAccounts)*100)
+		if env.statedb, err = state.New(head.Root, sdb); err != nil {
+			fmt.Printf("Failed to open StateDB at %x: %v\n", head.Root, err)
+			return
+		}
+		env.root, env.history = head.Root, []common.Hash{head.Root}
+		chain.number, chain.time = head.Block+1, head.Time+chain.period
+	} else if *bulkLoad {
+		var code []byte
+		if *workload == "evm" {
+			code = storageWriterCode
 		}
+		if err := bulkLoadState(env, diskdb, addrs, *nSlots, code); err != nil {
+			fmt.Printf("Failed to bulk load: %v\n", err)
+			return
+		}
+		chain.seal() // the bulk loaded state is the genesis block
+		growth.sample("create", *nAccounts*(*nSlots))
+	} else {
+		for i := 0; i < *nAccounts; i++ {
+			var addr common.Address
+			if ds != nil {
+				addr = ds.addrs[i]
+			} else {
+				addr = common.BytesToAddress(crypto.Keccak256([]byte(fmt.Sprintf("account-%d", i)))[:20])
+			}
+			addrs[i] = addr
+			env := shards[i%len(shards)]
 
-		// Periodic commit to keep memory usage low
-		if (i+1)%batchSize == 0 || i+1 == *nAccounts {
-			fmt.Printf("\n[Batch %d] Committing to disk...\n", (i/batchSize)+1)
-			root, err := statedb.Commit(uint64(i/batchSize), false, false)
-			if err != nil {
-				fmt.Printf("Failed to commit StateDB: %v\n", err)
-				return
+			if part.owns(i) {
+				env.statedb.SetBalance(addr, uint256.NewInt(1e18), tracing.BalanceChangeUnspecified)
+				env.statedb.SetNonce(addr, uint64(i), tracing.NonceChangeUnspecified)
+				touches.account(addr)
+				if *workload == "evm" {
+					env.statedb.SetCode(addr, storageWriterCode)
+				}
+
+				for j := 0; j < *nSlots; j++ {
+					var slotKey, slotVal common.Hash
+					if ds != nil {
+						slotKey, slotVal = ds.slotKeys[j], ds.slotVals[j]
+					} else {
+						slotKey = common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("slot-%d", j))))
+						slotVal = slotValue(*entropy, fmt.Sprintf("value-%d", j))
+					}
+					opStart := trace.start()
+					env.statedb.SetState(addr, slotKey, slotVal)
+					trace.record("create-slot", i, j, createBatches.batches, opStart)
+					touches.slot(addr, slotKey)
+				}
+				if err := ops.add(*nSlots); err != nil {
+					fmt.Printf("\nAborting: %v\n", err)
+					ops.report()
+					return
+				}
 			}
-			err = trieDB.Commit(root, false)
-			if err != nil {
-				fmt.Printf("Failed to commit TrieDB: %v\n", err)
-				return
+
+			if (i+1)%10 == 0 || i+1 == *nAccounts {
+				fmt.Printf("...processed %d/%d accounts (%.1f%%)\r", i+1, *nAccounts, float64(i+1)/float64(*nAccounts)*100)
+			}
+
+			// Periodic commit to keep memory usage low
+			if createBatches.full(i+1 == *nAccounts) {
+				fmt.Printf("\n[Batch %d] Committing to disk...\n", createBatches.batches+1)
+				opStart := trace.start()
+				if err := commits.commit(shards); err != nil {
+					fmt.Printf("Failed to commit: %v\n", err)
+					return
+				}
+				trace.record("create-commit", -1, -1, createBatches.batches, opStart)
+				createBatches.committed()
+				if err := compactor.batch(); err != nil {
+					fmt.Printf("Failed to compact: %v\n", err)
+					return
+				}
+				growth.sample("create", *nSlots*(i+1))
+				if router != nil {
+					router.sample("create", *nSlots*(i+1))
+				}
 			}
-			currentRoot = root
-			// Re-create statedb from the new root to release memory of dirty objects
-			statedb, _ = state.New(currentRoot, sdb)
-			runtime.GC() // Suggest GC to clean up
 		}
 	}
+	if err := flushShards(shards, false); err != nil {
+		fmt.Printf("Failed to commit: %v\n", err)
+		return
+	}
+	if err := env.flusher.sync(); err != nil {
+		fmt.Printf("Failed to flush: %v\n", err)
+		return
+	}
 	fmt.Println()
-	fmt.Printf("Creation finished in %v. Final Root: %x\n", time.Since(start), currentRoot)
+	createTime := time.Since(start)
+	fmt.Printf("Creation finished in %v. Final Root: %x\n", createTime, env.root)
+	createdRoot := env.root
+
+	// Modifications continue right after the last committed block, unless
+	// told to leave a gap.
+	if head == nil {
+		head = &benchHead{Accounts: uint64(*nAccounts), Slots: uint64(*nSlots), Part: uint64(part.index), Parts: uint64(part.count), Seed: uint64(*seed)}
+		head.Block, head.Time = chain.last()
+		head.Params = fmt.Sprintf("workload=%s m=%d k=%d memory-budget=%s value-entropy=%s start-block=%d block-time=%d dataset=%t",
+			*workload, *mModify, *kCommit, *memBudget, *entropy, *startBlk, *blockTime, ds != nil)
+	}
+	if *startBlk != 0 {
+		if *startBlk < chain.number {
+			fmt.Printf("Start block %d overlaps the recorded history up to block %d\n", *startBlk, chain.number-1)
+			return
+		}
+		chain.skip(*startBlk)
+	}
+	head.Root = env.root
+	if !env.unbounded {
+		writeBenchHead(diskdb, head)
+	}
+	if churnDB != nil && !modifyOnly {
+		churnDB.phase("Creation", *nAccounts, *nAccounts*(*nSlots))
+	}
+	for s := 1; s < len(shards); s++ {
+		fmt.Printf("Shard %d Root: %x\n", s, shards[s].root)
+	}
 
 	// 4. Phase 2: Modification
+	if run, ok := workloads[*workload]; ok {
+		fmt.Printf("Phase 2: Running %s workload with %d transactions (k=%d)...\n", *workload, *mModify, *kCommit)
+		system.begin("Workload")
+		start = time.Now()
+		cfg := &workloadConfig{
+			addrs:     addrs,
+			slots:     *nSlots,
+			txs:       *mModify,
+			batchSize: batchSize,
+			forks:     *nForks,
+			jwrites:   *jWrites,
+			cands:     *nCands,
+			dbPath:    *dbPath,
+			rand:      rand.New(rand.NewSource(*seed)),
+		}
+		if err := run(env, cfg); err != nil {
+			fmt.Printf("Workload %s failed: %v\n", *workload, err)
+			return
+		}
+		if err := flushShards(shards, true); err != nil {
+			fmt.Printf("Failed to commit: %v\n", err)
+			return
+		}
+		modifyTime := time.Since(start)
+		fmt.Printf("Workload finished in %v. Final New Root: %x\n", modifyTime, env.root)
+		system.close()
+		head.Root = env.root
+		head.Block, head.Time = chain.last()
+		writeBenchHead(diskdb, head)
+
+		size := getDirSize(*dbPath)
+		fmt.Printf("\n--- Final Report ---\n")
+		fmt.Printf("Database Path: %s\n", *dbPath)
+		fmt.Printf("Disk Usage:    %.2f MB\n", float64(size)/(1024*1024))
+		cfg.report()
+		system.report()
+		artifacts.collect(map[string]any{
+			"creationTime":     createTime,
+			"modificationTime": modifyTime,
+			"diskBytes":        size,
+			"root":             env.root,
+			"commits":          commits,
+			"workload":         cfg.lines,
+			"system":           system,
+		})
+		return
+	}
 	if *mModify > *nAccounts {
 		*mModify = *nAccounts
 	}
-	fmt.Printf("Phase 2: Randomly modifying slots in %d accounts (k=%d)...\n", *mModify, *kCommit)
+	if err := compactor.gap(); err != nil {
+		fmt.Printf("Failed to compact: %v\n", err)
+		return
+	}
+	accesses.begin("Modification")
+	system.begin("Modification")
+	fmt.Printf("Phase 2: Randomly modifying slots in %d accounts (k=%d, workload=%s, seed=%d)...\n", *mModify, *kCommit, *workload, *seed)
 	start = time.Now()
+	commits.phase()
 
-	// statedb is already updated to currentRoot from phase 1
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	perm := r.Perm(*nAccounts)
+	// statedb is already updated to the root from phase 1
+	var (
+		r        = rand.New(rand.NewSource(*seed))
+		perm     = r.Perm(*nAccounts)
+		gas      gasMeter
+		blockGas uint64
+		blockBeg = time.Now()
+		creates  int
+		codes    = newCodeStats(*nVariants)
+		witness  []int // EIP-4762 witness gas per transaction
+	)
+	if *workload == "evm" && *nCreates > 0 {
+		env.statedb.SetCode(create2Factory, create2FactoryCode)
+	}
 	for i := 0; i < *mModify; i++ {
+		var writes []common.Hash // slot key and value pairs streamed from the dataset
+		if ds != nil {
+			if perm[i], writes, err = ds.next(); err != nil {
+				fmt.Printf("Failed to read dataset: %v\n", err)
+				return
+			}
+		}
 		addr := addrs[perm[i]]
+		block := chain.number
+		env := shards[perm[i]%len(shards)]
+		owned := part.owns(perm[i])
+
+		var events *accessEvents
+		if *verkle {
+			events = newAccessEvents()
+			events.touchAccount(addr, true)
+			if *workload == "evm" {
+				events.touchCode(addr, len(storageWriterCode))
+			}
+		}
 
 		// Modify some slots randomly
+		touches.account(addr)
+		var calldata []byte
 		for j := 0; j < 500; j++ { // modify 500 random slots per account
-			slotIdx := r.Intn(*nSlots)
-			slotKey := common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("slot-%d", slotIdx))))
-			newVal := common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("new-value-%d-%d", i, j))))
-			statedb.SetState(addr, slotKey, newVal)
+			var (
+				slotKey, newVal common.Hash
+				slotIdx         = -1 // unknown for streamed datasets
+			)
+			if writes != nil {
+				slotKey, newVal = writes[2*j], writes[2*j+1]
+			} else {
+				slotIdx = r.Intn(*nSlots)
+				slotKey = common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("slot-%d", slotIdx))))
+				newVal = slotValue(*entropy, fmt.Sprintf("new-value-%d-%d", i, j))
+			}
+			if !owned {
+				continue // drawn all the same, keeping the random stream in step
+			}
+			if events != nil {
+				events.touchSlot(addr, slotKey, true, env.statedb.GetState(addr, slotKey) == (common.Hash{}))
+			}
+			touches.slot(addr, slotKey)
+			if *workload == "evm" {
+				calldata = append(calldata, slotKey.Bytes()...)
+				calldata = append(calldata, newVal.Bytes()...)
+			} else {
+				opStart := trace.start()
+				env.statedb.SetState(addr, slotKey, newVal)
+				trace.record("modify-slot", perm[i], slotIdx, modifyBatches.batches, opStart)
+			}
+		}
+		if *workload == "evm" && owned {
+			opStart := trace.start()
+			used, err := env.call(addr, calldata)
+			trace.record("call", perm[i], -1, modifyBatches.batches, opStart)
+			if err != nil {
+				fmt.Printf("Failed to execute call to %x: %v\n", addr, err)
+				return
+			}
+			blockGas += used
+		}
+		if events != nil {
+			witness = append(witness, int(events.gas))
+		}
+		if err := ops.add(500); err != nil {
+			fmt.Printf("\nAborting: %v\n", err)
+			ops.report()
+			return
 		}
 
 		if (i+1)%10 == 0 || i+1 == *mModify {
@@ -123,31 +820,412 @@ func main() {
 		}
 
 		// Modification periodic commit
-		if (i+1)%batchSize == 0 || i+1 == *mModify {
+		if modifyBatches.full(i+1 == *mModify) {
+			if *workload == "evm" {
+				// Deployments close the block, as in a busy NFT drop
+				for c := 0; c < *nCreates; c++ {
+					ctor := make([]common.Hash, *ctorSlots)
+					for j := range ctor {
+						ctor[j] = crypto.Keccak256Hash([]byte(fmt.Sprintf("ctor-%d-%d", creates, j)))
+					}
+					code := codes.pick(r)
+					used, err := shards[0].create(initCode(ctor, code), creates%2 == 1)
+					if err != nil {
+						fmt.Printf("Failed to deploy contract: %v\n", err)
+						return
+					}
+					blockGas += used
+					creates++
+				}
+			}
 			fmt.Printf("\n[Mod Batch] Committing to disk...\n")
-			root, err := statedb.Commit(uint64(i/batchSize)+1000000, false, false) // different block space
-			if err != nil {
+			opStart := trace.start()
+			if err := commits.commit(shards); err != nil {
 				fmt.Printf("Failed to commit modifications: %v\n", err)
 				return
 			}
-			err = trieDB.Commit(root, false)
-			if err != nil {
-				fmt.Printf("Failed to commit TrieDB (mod): %v\n", err)
+			trace.record("modify-commit", -1, -1, modifyBatches.batches, opStart)
+			modifyBatches.committed()
+			if err := compactor.batch(); err != nil {
+				fmt.Printf("Failed to compact: %v\n", err)
 				return
 			}
-			currentRoot = root
-			statedb, _ = state.New(currentRoot, sdb)
-			runtime.GC()
+			growth.sample("modify", *nAccounts*(*nSlots)+500*(i+1))
+			if router != nil {
+				router.sample("modify", *nAccounts*(*nSlots)+500*(i+1))
+			}
+			if *workload == "evm" {
+				elapsed := time.Since(blockBeg)
+				gas.add(blockGas, elapsed)
+				fmt.Printf("[Block %d] gas=%d time=%v (%.2f Mgas/s)\n", block, blockGas, elapsed, mgasPerSec(blockGas, elapsed))
+			}
+			blockGas, blockBeg = 0, time.Now()
 		}
 	}
 	fmt.Println()
-	fmt.Printf("Modification finished in %v. Final New Root: %x\n", time.Since(start), currentRoot)
+	if err := flushShards(shards, true); err != nil {
+		fmt.Printf("Failed to commit modifications: %v\n", err)
+		return
+	}
+	if err := env.flusher.close(); err != nil {
+		fmt.Printf("Failed to flush modifications: %v\n", err)
+		return
+	}
+	flusher := env.flusher
+	env.flusher = nil // later phases flush inline
+	modifyTime := time.Since(start)
+	fmt.Printf("Modification finished in %v. Final New Root: %x\n", modifyTime, env.root)
+	if *mModify > 0 {
+		head.Root = env.root
+		head.Block, head.Time = chain.last()
+		writeBenchHead(diskdb, head)
+	}
+	if churnDB != nil {
+		churnDB.phase("Modification", *mModify, 500*(*mModify))
+	}
+	mptRoot, mptSize := env.root, getDirSize(*dbPath)
+
+	// Phase 2b: Balance and nonce bumps alone, isolating the account trie
+	var accountResult *accountUpdateResult
+	if *acctUpds > 0 {
+		accesses.begin("Account Updates")
+		system.begin("Account Updates")
+		fmt.Printf("Phase 2b: Bumping balances and nonces of %d accounts (k=%d)...\n", *acctUpds, *kCommit)
+		accountResult, err = runAccountUpdates(shards, addrs, *acctUpds, batchSize, r)
+		if err != nil {
+			fmt.Printf("Account update phase failed: %v\n", err)
+			return
+		}
+		fmt.Printf("Account updates finished in %v. Final New Root: %x\n", accountResult.elapsed, env.root)
+		head.Root = env.root
+		head.Block, head.Time = chain.last()
+		writeBenchHead(diskdb, head)
+		if churnDB != nil {
+			churnDB.phase("Account Upd.", *acctUpds, 0)
+		}
+	}
+	accesses.begin("Reads")
+	if trace != nil {
+		if err := trace.write(*traceFile); err != nil {
+			fmt.Printf("Failed to write latency trace: %v\n", err)
+			return
+		}
+		fmt.Printf("Sampled %d of %d operation latencies to %s\n", len(trace.samples), trace.seen, *traceFile)
+	}
+	if touches != nil {
+		if err := touches.write(*touchFile); err != nil {
+			fmt.Printf("Failed to write touch counts: %v\n", err)
+			return
+		}
+		fmt.Printf("Exported touch counts of %d accounts and %d slots to %s\n", len(touches.accounts), len(touches.slots), *touchFile)
+	}
+	if *compact != "" {
+		if err := compactor.gap(); err != nil {
+			fmt.Printf("Failed to compact: %v\n", err)
+			return
+		}
+		if err := compactor.finish(env, addrs, *nSlots, r); err != nil {
+			fmt.Printf("Final read measurement failed: %v\n", err)
+			return
+		}
+	}
+
+	// Same key/value stream against a flat state layout
+	var flatResult *flatState
+	if *flat {
+		flatPath := *dbPath + "-flat"
+		fmt.Printf("Replaying the workload against flat state at %s...\n", flatPath)
+		system.begin("Flat Replay")
+		os.RemoveAll(flatPath)
+		fdb, err := leveldb.New(flatPath, 256, 1024, "eth/db/flat/", false)
+		if err != nil {
+			fmt.Printf("Failed to open flat LevelDB: %v\n", err)
+			return
+		}
+		flatResult = &flatState{db: fdb}
+		err = flatResult.run(*nAccounts, *nSlots, *mModify, batchSize, rand.New(rand.NewSource(*seed)))
+		fdb.Close()
+		if err != nil {
+			fmt.Printf("Flat state run failed: %v\n", err)
+			return
+		}
+		flatResult.size = getDirSize(flatPath)
+	}
+
+	// Phase 3: Reads from a pinned state view under concurrent writes
+	var snapResult *snapshotReadResult
+	if *snapReads > 0 {
+		fmt.Printf("Phase 3: Serving %d reads from root %x while modifications continue...\n", *snapReads, env.root)
+		system.begin("Pinned Reads")
+		snapResult, err = runSnapshotReads(env, addrs, *nSlots, *snapReads, r)
+		if err != nil {
+			fmt.Printf("Snapshot read phase failed: %v\n", err)
+			return
+		}
+	}
+
+	// Phase 4: Moving historical trie nodes into cold storage
+	var freezeResult *freezeResult
+	if *freezeOld {
+		fmt.Printf("Phase 4: Freezing trie nodes of %d historical roots...\n", len(env.history)-1)
+		system.begin("Freezing")
+		freezeResult, err = freezeHistoricalNodes(env, diskdb, filepath.Join(*dbPath, "ancient_trie"), createdRoot, addrs, *nSlots, r)
+		if err != nil {
+			fmt.Printf("Freezing failed: %v\n", err)
+			return
+		}
+	}
+
+	// Phase 5: Querying the same key at historical roots, like eth_call at old blocks
+	var archiveResult *archiveReadResult
+	if *archReads > 0 {
+		fmt.Printf("Phase 5: Serving %d historical queries across %d retained roots...\n", *archReads, len(env.history))
+		system.begin("Archive Reads")
+		archiveResult, err = runArchiveReads(env, addrs[0], *archReads, r)
+		if err != nil {
+			fmt.Printf("Historical read phase failed: %v\n", err)
+			return
+		}
+	}
+
+	// Phase 6: Lookups of absent keys, with and without an existence filter
+	var negativeResult *negativeReadResult
+	if *negReads > 0 {
+		fmt.Printf("Phase 6: Looking up %d absent accounts and slots...\n", *negReads)
+		system.begin("Absent Lookups")
+		negativeResult, err = runNegativeReads(env, counter, addrs, *nSlots, *negReads)
+		if err != nil {
+			fmt.Printf("Negative lookup phase failed: %v\n", err)
+			return
+		}
+	}
+
+	// Phase 7: Combined proofs for many keys against one proof per key
+	var multiproofResult []multiproofRow
+	if *multiAccs > 0 {
+		fmt.Printf("Phase 7: Proving up to %d accounts at once...\n", *multiAccs)
+		system.begin("Multiproofs")
+		multiproofResult, err = runMultiproofs(env, addrs, *nSlots, *multiAccs, r)
+		if err != nil {
+			fmt.Printf("Multiproof phase failed: %v\n", err)
+			return
+		}
+	}
+
+	// Phase 8: Proof verification by a light client holding only the state root
+	var lightResult *lightVerifyResult
+	if *lightVfy > 0 {
+		fmt.Printf("Phase 8: Verifying %d account and slot proofs against root %x...\n", *lightVfy, env.root)
+		system.begin("Light Verify")
+		lightResult, err = runLightVerification(env, addrs, *nSlots, *lightVfy, r)
+		if err != nil {
+			fmt.Printf("Light client verification failed: %v\n", err)
+			return
+		}
+	}
+
+	// Phase 9: Storage reads with storage trie nodes clustered by account
+	var clusterResult *clusterResult
+	if *clusterN > 0 {
+		fmt.Printf("Phase 9: Copying root %x into a global and an account-clustered layout...\n", env.root)
+		system.begin("Clustering")
+		clusterResult, err = compareStorageClustering(env, *dbPath, addrs, *nSlots, *clusterN, r)
+		if err != nil {
+			fmt.Printf("Storage clustering experiment failed: %v\n", err)
+			return
+		}
+	}
+
+	// Phase 10: Lookups traced node by node down the trie levels
+	var readTraceResult *tracingNodeDB
+	if *readTrace > 0 {
+		fmt.Printf("Phase 10: Tracing %d account and slot lookups level by level...\n", *readTrace)
+		system.begin("Read Trace")
+		readTraceResult, err = traceReadPaths(env, counter, addrs, *nSlots, *readTrace, r)
+		if err != nil {
+			fmt.Printf("Read-path tracing failed: %v\n", err)
+			return
+		}
+	}
+
+	// Phase 11: Reads through a growing stack of snapshot diff layers
+	var layerResult *snapshotLayerResult
+	if *snapLayrs > 0 {
+		fmt.Printf("Phase 11: Stacking %d snapshot diff layers on root %x...\n", *snapLayrs, env.root)
+		system.begin("Snapshot Layers")
+		layerResult, err = runSnapshotLayers(env, *dbPath, addrs, *nSlots, *snapLayrs, r)
+		if err != nil {
+			fmt.Printf("Snapshot layer phase failed: %v\n", err)
+			return
+		}
+	}
+
+	// Phase 12: A blend of RPC calls from concurrent clients
+	var queryResult *queryMixResult
+	if *queryMix > 0 {
+		workers := *queryConc
+		if workers <= 0 {
+			workers = runtime.GOMAXPROCS(0)
+		}
+		fmt.Printf("Phase 12: Serving %d RPC calls from %d clients against root %x...\n", *queryMix, workers, env.root)
+		system.begin("Query Mix")
+		queryResult, err = runQueryMix(env, addrs, *nSlots, *queryMix, workers, weights, r)
+		if err != nil {
+			fmt.Printf("Query mix failed: %v\n", err)
+			return
+		}
+	}
+
+	system.close()
 
 	// 5. Final Report
 	size := getDirSize(*dbPath)
 	fmt.Printf("\n--- Final Report ---\n")
 	fmt.Printf("Database Path: %s\n", *dbPath)
 	fmt.Printf("Disk Usage:    %.2f MB\n", float64(size)/(1024*1024))
+	if *workload == "evm" {
+		gas.report()
+		if creates > 0 {
+			fmt.Printf("Deployments:   %d (%d constructor slots each)\n", creates, *ctorSlots)
+			codes.report()
+		}
+	}
+	if *verkle {
+		fmt.Printf("Witness Gas:   %s per tx (EIP-4762)\n", distribution(witness))
+	}
+	for s := 1; s < len(shards); s++ {
+		fmt.Printf("Shard %d Root:  %x\n", s, shards[s].root)
+	}
+	commits.report()
+	if flusher != nil {
+		flusher.report()
+	}
+	if budget > 0 {
+		fmt.Printf("\n--- Batch Sizes (budget %d MB) ---\n", budget/(1024*1024))
+		createBatches.report("Creation")
+		modifyBatches.report("Modification")
+	}
+	if *compact != "" {
+		compactor.report()
+	}
+	reportHeap(*sdbMode, shards)
+	if *sdbMode == "unbounded" {
+		ops.report()
+	}
+	growth.report()
+	if router != nil {
+		router.report()
+	}
+	if churnDB != nil {
+		churnDB.report()
+	}
+	if accesses != nil {
+		accesses.report()
+	}
+	if snapResult != nil {
+		snapResult.report()
+	}
+	if freezeResult != nil {
+		freezeResult.report()
+	}
+	if archiveResult != nil {
+		archiveResult.report()
+	}
+	if negativeResult != nil {
+		negativeResult.report()
+	}
+	if multiproofResult != nil {
+		reportMultiproofs(multiproofResult)
+	}
+	if lightResult != nil {
+		lightResult.report()
+	}
+	if clusterResult != nil {
+		clusterResult.report()
+	}
+	if accountResult != nil {
+		accountResult.report(modifyTime, *mModify)
+	}
+	if readTraceResult != nil {
+		readTraceResult.report()
+	}
+	if layerResult != nil {
+		layerResult.report()
+	}
+	if queryResult != nil {
+		queryResult.report()
+	}
+	system.report()
+	if *rawKeys {
+		compareKeyHashing(addrs, *nSlots)
+	}
+	if *entropy != "" {
+		if err := reportCompressibility(env, *entropy, size); err != nil {
+			fmt.Printf("Compressibility report failed: %v\n", err)
+		}
+	}
+	if flatResult != nil {
+		fmt.Printf("\n--- MPT vs Flat ---\n")
+		fmt.Printf("%-14s %14s %14s\n", "", "MPT", "Flat")
+		fmt.Printf("%-14s %14v %14v\n", "Creation", createTime.Round(time.Millisecond), flatResult.createTime.Round(time.Millisecond))
+		fmt.Printf("%-14s %14v %14v\n", "Modification", modifyTime.Round(time.Millisecond), flatResult.modifyTime.Round(time.Millisecond))
+		fmt.Printf("%-14s %14s %14v\n", "Root", "(inline)", flatResult.rootTime.Round(time.Millisecond))
+		fmt.Printf("%-14s %14.2f %14.2f\n", "Disk (MB)", float64(mptSize)/(1024*1024), float64(flatResult.size)/(1024*1024))
+		fmt.Printf("Roots Match:   %v\n", flatResult.root == mptRoot)
+	}
+	if *hashAlts != "" {
+		if err := compareHashFunctions(env, strings.Split(*hashAlts, ",")); err != nil {
+			fmt.Printf("Hash function comparison failed: %v\n", err)
+		}
+	}
+	var churnPhases []churnPhase
+	if churnDB != nil {
+		churnPhases = churnDB.phases
+	}
+	artifacts.collect(map[string]any{
+		"creationTime":     createTime,
+		"modificationTime": modifyTime,
+		"diskBytes":        size,
+		"root":             env.root,
+		"commits":          commits,
+		"gas":              gas,
+		"growth":           growth,
+		"churn":            churnPhases,
+		"system":           system,
+		"accountUpdates":   accountResult,
+		"flat":             flatResult,
+		"snapshotReads":    snapResult,
+		"freeze":           freezeResult,
+		"archiveReads":     archiveResult,
+		"negativeReads":    negativeResult,
+		"multiproofs":      multiproofResult,
+		"lightVerify":      lightResult,
+		"clustering":       clusterResult,
+		"readTrace":        readTraceResult,
+		"snapshotLayers":   layerResult,
+		"queryMix":         queryResult,
+	})
+}
+
+// openDiskStore opens the on-disk key-value store of the given backend.
+func openDiskStore(backend, path string) (ethdb.KeyValueStore, error) {
+	if backend == "pebble" {
+		return pebble.New(path, 256, 1024, "eth/db/chaindata/", false, false)
+	}
+	return leveldb.New(path, 256, 1024, "eth/db/chaindata/", false)
+}
+
+// distribution summarises integer samples as min/median/p90/p99/max.
+func distribution(samples []int) string {
+	if len(samples) == 0 {
+		return "n/a"
+	}
+	sorted := append([]int(nil), samples...)
+	sort.Ints(sorted)
+	at := func(p float64) int {
+		return sorted[int(p*float64(len(sorted)-1))]
+	}
+	return fmt.Sprintf("min=%d p50=%d p90=%d p99=%d max=%d", sorted[0], at(0.5), at(0.9), at(0.99), sorted[len(sorted)-1])
 }
 
 func getDirSize(path string) int64 {
@@ -166,4 +1244,3 @@ func getDirSize(path string) int64 {
 	}
 	return size
 }
-
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// artifactBundle collects everything a run produces in one timestamped
+// directory: the log of its output, the configuration with the effective
+// seed, CPU and heap profiles, the result structs of all phases as JSON and
+// copies of the CSV series and state diffs, wherever they were written.
+type artifactBundle struct {
+	dir     string
+	tgz     bool
+	stdout  *os.File // the real standard output
+	pipe    *os.File // write end of the pipe standing in for it
+	log     *os.File
+	copied  chan struct{}
+	cpu     *os.File
+	results map[string]any
+}
+
+// newArtifactBundle creates the run directory, writes the configuration and
+// starts teeing the output into the log and profiling the CPU. It has to be
+// called once the seed is final.
+func newArtifactBundle(root string, tgz bool) (*artifactBundle, error) {
+	ab := &artifactBundle{dir: filepath.Join(root, "run-"+time.Now().Format("20060102-150405")), tgz: tgz}
+	if err := os.MkdirAll(ab.dir, 0755); err != nil {
+		return nil, err
+	}
+	flags := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+	config, err := json.MarshalIndent(map[string]any{
+		"args":    os.Args,
+		"flags":   flags,
+		"go":      runtime.Version(),
+		"os":      runtime.GOOS + "/" + runtime.GOARCH,
+		"cpus":    runtime.NumCPU(),
+		"started": time.Now().Format(time.RFC3339),
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(ab.dir, "config.json"), config, 0644); err != nil {
+		return nil, err
+	}
+	if ab.log, err = os.Create(filepath.Join(ab.dir, "run.log")); err != nil {
+		return nil, err
+	}
+	if ab.cpu, err = os.Create(filepath.Join(ab.dir, "cpu.pprof")); err != nil {
+		return nil, err
+	}
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(ab.cpu); err != nil {
+		return nil, err
+	}
+	ab.stdout, ab.pipe, ab.copied = os.Stdout, writer, make(chan struct{})
+	os.Stdout = writer
+	go func() {
+		io.Copy(io.MultiWriter(ab.stdout, ab.log), reader)
+		close(ab.copied)
+	}()
+	return ab, nil
+}
+
+// collect hands over the results of the run, which close writes into
+// report.json. Phases that did not run are left as nil.
+func (ab *artifactBundle) collect(results map[string]any) {
+	if ab == nil {
+		return
+	}
+	ab.results = results
+}
+
+// close finishes the profiles and the log, writes the collected results as
+// JSON, copies in the given output files and directories and packs the bundle
+// if requested.
+func (ab *artifactBundle) close(outputs ...string) {
+	pprof.StopCPUProfile()
+	ab.cpu.Close()
+	if heap, err := os.Create(filepath.Join(ab.dir, "heap.pprof")); err == nil {
+		pprof.WriteHeapProfile(heap)
+		heap.Close()
+	}
+	os.Stdout = ab.stdout
+	ab.pipe.Close()
+	<-ab.copied
+	ab.log.Close()
+
+	if ab.results != nil {
+		report := make(map[string]any, len(ab.results))
+		for name, res := range ab.results {
+			report[name] = jsonValue(reflect.ValueOf(res), 0)
+		}
+		blob, err := json.MarshalIndent(report, "", "  ")
+		if err == nil {
+			err = os.WriteFile(filepath.Join(ab.dir, "report.json"), blob, 0644)
+		}
+		if err != nil {
+			fmt.Printf("Failed to write the JSON report: %v\n", err)
+		}
+	}
+	for _, path := range outputs {
+		if path == "" {
+			continue
+		}
+		if err := copyTree(path, filepath.Join(ab.dir, filepath.Base(path))); err != nil {
+			fmt.Printf("Failed to collect %s: %v\n", path, err)
+		}
+	}
+	if !ab.tgz {
+		fmt.Printf("Artifacts written to %s\n", ab.dir)
+		return
+	}
+	if err := packTarGz(ab.dir, ab.dir+".tar.gz"); err != nil {
+		fmt.Printf("Failed to pack artifacts: %v\n", err)
+		return
+	}
+	os.RemoveAll(ab.dir)
+	fmt.Printf("Artifacts written to %s.tar.gz\n", ab.dir)
+}
+
+// jsonValue converts a result into JSON-encodable values field by field,
+// unexported fields included, since the result structs keep their figures
+// private. Durations become strings, byte arrays like hashes and addresses
+// hex, and timestamps, database handles, locks and channels are left out.
+func jsonValue(v reflect.Value, depth int) any {
+	if !v.IsValid() || depth > 8 {
+		return nil
+	}
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		return time.Duration(v.Int()).String()
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint()
+	case reflect.Float32, reflect.Float64:
+		if f := v.Float(); !math.IsNaN(f) && !math.IsInf(f, 0) {
+			return f
+		}
+		return nil
+	case reflect.String:
+		return v.String()
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		return jsonValue(v.Elem(), depth+1)
+	case reflect.Array, reflect.Slice:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			blob := make([]byte, v.Len())
+			for i := range blob {
+				blob[i] = byte(v.Index(i).Uint())
+			}
+			return hexutil.Encode(blob)
+		}
+		list := make([]any, v.Len())
+		for i := range list {
+			list[i] = jsonValue(v.Index(i), depth+1)
+		}
+		return list
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		fields := make(map[string]any, v.Len())
+		for it := v.MapRange(); it.Next(); {
+			fields[fmt.Sprint(jsonValue(it.Key(), depth+1))] = jsonValue(it.Value(), depth+1)
+		}
+		return fields
+	case reflect.Struct:
+		if opaqueStruct(v.Type()) {
+			return nil
+		}
+		fields := make(map[string]any)
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			switch field.Type.Kind() {
+			case reflect.Interface, reflect.Func, reflect.Chan, reflect.UnsafePointer:
+				continue
+			}
+			if field.Type.Kind() == reflect.Struct && opaqueStruct(field.Type) {
+				continue
+			}
+			value := jsonValue(v.Field(i), depth+1)
+			if embedded, ok := value.(map[string]any); ok && field.Anonymous {
+				for name, v := range embedded {
+					fields[name] = v
+				}
+				continue
+			}
+			fields[field.Name] = value
+		}
+		return fields
+	}
+	return nil
+}
+
+// opaqueStruct reports whether a struct type keeps nothing but internal state,
+// like locks and timestamps.
+func opaqueStruct(t reflect.Type) bool {
+	switch t.PkgPath() {
+	case "sync", "sync/atomic", "time":
+		return true
+	}
+	return false
+}
+
+// copyTree copies a file, or a directory with everything below it.
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
+
+// packTarGz writes the directory dir into a gzipped tarball at path, with
+// entries relative to the parent of dir.
+func packTarGz(dir, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var (
+		gz = gzip.NewWriter(file)
+		tw = tar.NewWriter(gz)
+	)
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		if hdr.Name, err = filepath.Rel(filepath.Dir(dir), p); err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(hdr.Name)
+		if err := tw.WriteHeader(hdr); err != nil || info.IsDir() {
+			return err
+		}
+		in, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		_, err = io.Copy(tw, in)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
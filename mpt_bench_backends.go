@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/triedb"
+)
+
+// backendResult holds the figures a run against a single backend reported.
+type backendResult struct {
+	backend    string
+	createTime time.Duration
+	modifyTime time.Duration
+	diskMB     float64
+	root       string
+	err        error
+}
+
+// parse picks the phase timings, disk usage and final root out of the output
+// of a benchmark run.
+func (res *backendResult) parse(output string) {
+	elapsed := func(line string) time.Duration {
+		d, _ := time.ParseDuration(strings.TrimSuffix(strings.Fields(line)[3], "."))
+		return d
+	}
+	for _, line := range strings.Split(output, "\n") {
+		switch {
+		case strings.HasPrefix(line, "Creation finished in "):
+			res.createTime = elapsed(line)
+		case strings.HasPrefix(line, "Modification finished in "), strings.HasPrefix(line, "Workload finished in "):
+			res.modifyTime = elapsed(line)
+			if _, root, ok := strings.Cut(line, "Root: "); ok {
+				res.root = root
+			}
+		case strings.HasPrefix(line, "Disk Usage:"):
+			fmt.Sscanf(line, "Disk Usage: %f MB", &res.diskMB)
+		}
+	}
+}
+
+// childArgs returns the arguments of the current invocation without the named
+// flags, and without the subcommand if requested, for re-running it in a child
+// process. Flags appended to them override the remaining ones.
+func childArgs(dropCommand bool, drop ...string) []string {
+	var args []string
+	for i := 1; i < len(os.Args); i++ {
+		if i == 1 && dropCommand && !strings.HasPrefix(os.Args[i], "-") {
+			continue
+		}
+		name, _, hasValue := strings.Cut(strings.TrimLeft(os.Args[i], "-"), "=")
+		if slices.Contains(drop, name) {
+			if !hasValue {
+				i++ // the value is the next argument
+			}
+			continue
+		}
+		args = append(args, os.Args[i])
+	}
+	return args
+}
+
+// crashResult is the outcome of killing and recovering a run on one backend.
+type crashResult struct {
+	backend   string
+	acked     []common.Hash // roots the run reported as committed, in order
+	finished  bool          // the run completed before it could be killed
+	recovered int           // index of the newest acked root that reopens, -1 if none
+
+	openTime, recoverTime time.Duration
+	err                   error
+}
+
+// runCrashTests runs the current invocation once per backend in a child
+// process, each in a fresh directory with the same seed, and kills it with
+// SIGKILL after the given time. The database is then reopened and the newest
+// root the child acknowledged as committed that still opens is searched, which
+// yields the window of acknowledged commits lost in the crash and the time to
+// recover. Only the process dies, the operating system keeps its page cache,
+// so the harness covers process crashes and not power loss.
+func runCrashTests(backends []string, dbPath, scheme string, seed int64, after time.Duration) {
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Printf("Failed to locate the benchmark binary: %v\n", err)
+		return
+	}
+	var (
+		args    = childArgs(true, "backends", "crash-after", "artifact-dir")
+		results []*crashResult
+	)
+	for _, backend := range backends {
+		res := &crashResult{backend: backend, recovered: -1}
+		results = append(results, res)
+		if backend != "leveldb" && backend != "pebble" {
+			res.err = fmt.Errorf("backend %q does not persist", backend)
+			continue
+		}
+		path := dbPath + "-crash-" + backend
+		fmt.Printf("Running on %s at %s, killing it after %v...\n", backend, path, after)
+
+		cmd := exec.Command(exe, append(args, "-backend="+backend, "-db="+path, fmt.Sprintf("-seed=%d", seed), "-clear=true", "-announce-commits")...)
+		cmd.Stderr = os.Stderr
+		stdout, err := cmd.StdoutPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			res.err = err
+			continue
+		}
+		timer := time.AfterFunc(after, func() { cmd.Process.Kill() })
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			var (
+				block uint64
+				root  string
+			)
+			if n, _ := fmt.Sscanf(scanner.Text(), "[Committed] block=%d root=%s", &block, &root); n == 2 {
+				res.acked = append(res.acked, common.HexToHash(root))
+			}
+		}
+		res.finished = cmd.Wait() == nil
+		timer.Stop()
+
+		start := time.Now()
+		kvdb, err := openDiskStore(backend, path)
+		if err != nil {
+			res.err = err
+			continue
+		}
+		res.openTime = time.Since(start)
+
+		config := triedb.HashDefaults
+		if scheme == "path" {
+			config = pathDefaults
+		}
+		var (
+			diskdb = rawdb.NewDatabase(kvdb)
+			tdb    = triedb.NewDatabase(diskdb, config)
+			sdb    = state.NewDatabase(tdb, nil)
+		)
+		for i := len(res.acked) - 1; i >= 0; i-- {
+			if _, err := state.New(res.acked[i], sdb); err == nil {
+				res.recovered = i
+				break
+			}
+		}
+		res.recoverTime = time.Since(start)
+		tdb.Close()
+		diskdb.Close()
+	}
+	fmt.Printf("\n--- Crash Recovery (killed after %v, seed=%d) ---\n", after, seed)
+	fmt.Printf("%-10s %8s %10s %6s %12s %14s\n", "Backend", "Acked", "Recovered", "Lost", "Open (ms)", "Recovery (ms)")
+	for _, res := range results {
+		switch {
+		case res.err != nil:
+			fmt.Printf("%-10s failed: %v\n", res.backend, res.err)
+		case res.finished:
+			fmt.Printf("%-10s finished before the crash, %d commits\n", res.backend, len(res.acked))
+		default:
+			fmt.Printf("%-10s %8d %10d %6d %12d %14d\n", res.backend, len(res.acked), res.recovered+1, len(res.acked)-1-res.recovered,
+				res.openTime.Milliseconds(), res.recoverTime.Milliseconds())
+		}
+	}
+}
+
+// runBackends repeats the current invocation once per backend, each in a child
+// process of its own so that no caches or heap carry over, against a separate
+// directory and with the same seed. The figures of all runs are compared in a
+// single table at the end.
+func runBackends(backends []string, dbPath string, seed int64) {
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Printf("Failed to locate the benchmark binary: %v\n", err)
+		return
+	}
+	var (
+		args    = childArgs(false, "backends", "artifact-dir")
+		results []*backendResult
+	)
+	for _, backend := range backends {
+		path := dbPath + "-" + backend
+		fmt.Printf("=== Backend %s (%s) ===\n", backend, path)
+
+		var out bytes.Buffer
+		cmd := exec.Command(exe, append(args, "-backend="+backend, "-db="+path, fmt.Sprintf("-seed=%d", seed))...)
+		cmd.Stdout = io.MultiWriter(os.Stdout, &out)
+		cmd.Stderr = os.Stderr
+
+		res := &backendResult{backend: backend, err: cmd.Run()}
+		res.parse(out.String())
+		results = append(results, res)
+		fmt.Println()
+	}
+	fmt.Printf("\n--- Backend Comparison (seed=%d) ---\n", seed)
+	fmt.Printf("%-10s %14s %14s %12s %s\n", "Backend", "Creation", "Modification", "Disk (MB)", "Final Root")
+	for _, res := range results {
+		if res.err != nil {
+			fmt.Printf("%-10s failed: %v\n", res.backend, res.err)
+			continue
+		}
+		fmt.Printf("%-10s %14v %14v %12.2f %s\n", res.backend, res.createTime.Round(time.Millisecond), res.modifyTime.Round(time.Millisecond), res.diskMB, res.root)
+	}
+	for _, res := range results[1:] {
+		if res.root != results[0].root {
+			fmt.Printf("Warning: final roots differ between %s and %s\n", results[0].backend, res.backend)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/triedb"
+	"github.com/holiman/uint256"
+)
+
+// bulkLoadState builds the Phase 1 state without a statedb: the hashed slot
+// keys of each account are sorted and streamed into a StackTrie, and so are
+// the hashed account keys afterwards, with every finished node written
+// straight into a database batch. It fills in addrs and leaves env at the
+// resulting root, which is identical to the one Phase 1 would produce.
+func bulkLoadState(env *benchEnv, db ethdb.Database, addrs []common.Address, nSlots int, code []byte) error {
+	var (
+		batch = db.NewBatch()
+		werr  error // first failed batch write, the stack trie cannot return it
+	)
+	writeNode := func(path []byte, hash common.Hash, blob []byte) {
+		if werr != nil {
+			return
+		}
+		rawdb.WriteLegacyTrieNode(batch, hash, blob)
+		if batch.ValueSize() > ethdb.IdealBatchSize {
+			if werr = batch.Write(); werr != nil {
+				return
+			}
+			batch.Reset()
+		}
+	}
+	codeHash := types.EmptyCodeHash
+	if len(code) > 0 {
+		codeHash = crypto.Keccak256Hash(code)
+		rawdb.WriteCode(batch, codeHash, code)
+	}
+	type kv struct {
+		key, value []byte
+	}
+	slots := make([]kv, nSlots)
+	for j := range slots {
+		slotKey := common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("slot-%d", j))))
+		slotVal := common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("value-%d", j))))
+		slots[j].key = crypto.Keccak256(slotKey[:])
+		slots[j].value, _ = rlp.EncodeToBytes(common.TrimLeftZeroes(slotVal[:]))
+	}
+	sort.Slice(slots, func(a, b int) bool { return bytes.Compare(slots[a].key, slots[b].key) < 0 })
+
+	accounts := make([]kv, len(addrs))
+	for i := range addrs {
+		addrs[i] = common.BytesToAddress(crypto.Keccak256([]byte(fmt.Sprintf("account-%d", i)))[:20])
+
+		// Every account gets its own storage trie built, even though they all
+		// share the same layout, to keep the comparison with Phase 1 fair.
+		st := trie.NewStackTrie(writeNode)
+		for _, slot := range slots {
+			if err := st.Update(slot.key, slot.value); err != nil {
+				return err
+			}
+		}
+		storageRoot := st.Hash()
+		if werr != nil {
+			return werr
+		}
+		accounts[i].key = crypto.Keccak256(addrs[i][:])
+		accounts[i].value, _ = rlp.EncodeToBytes(&types.StateAccount{
+			Nonce:    uint64(i),
+			Balance:  uint256.NewInt(1e18),
+			Root:     storageRoot,
+			CodeHash: codeHash.Bytes(),
+		})
+		if (i+1)%10 == 0 || i+1 == len(addrs) {
+			fmt.Printf("...loaded %d/%d storage tries (%.1f%%)\r", i+1, len(addrs), float64(i+1)/float64(len(addrs))*100)
+		}
+	}
+	sort.Slice(accounts, func(a, b int) bool { return bytes.Compare(accounts[a].key, accounts[b].key) < 0 })
+
+	st := trie.NewStackTrie(writeNode)
+	for _, acc := range accounts {
+		if err := st.Update(acc.key, acc.value); err != nil {
+			return err
+		}
+	}
+	root := st.Hash()
+	if werr != nil {
+		return werr
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	statedb, err := state.New(root, env.sdb)
+	if err != nil {
+		return err
+	}
+	env.root, env.statedb = root, statedb
+	env.history = append(env.history, root)
+	return nil
+}
+
+// mergeStates combines the parts of a state, built by separate runs with
+// -shard, into a fresh database at dbPath. Storage tries and code are
+// complete in every part and copied node by node, each distinct storage trie
+// once. The account trie of a part only holds the accounts of that part, so
+// its leaves are collected instead and the unified account trie is rebuilt
+// from all of them with a stack trie. The merged state is then walked in
+// full to verify that every node is present and every account accounted for.
+func mergeStates(backend, dbPath string, sources []string) error {
+	os.RemoveAll(dbPath)
+	kvdb, err := openDiskStore(backend, dbPath)
+	if err != nil {
+		return err
+	}
+	diskdb := rawdb.NewDatabase(kvdb)
+	defer diskdb.Close()
+
+	type leaf struct {
+		key, value []byte
+	}
+	var (
+		merged   *benchHead
+		parts    = make(map[uint64]string)
+		leaves   []leaf
+		copied   = make(map[common.Hash]bool) // storage roots
+		nodes    int
+		size     int64
+		batch    = diskdb.NewBatch()
+		start    = time.Now()
+		writeErr error
+	)
+	write := func() {
+		if batch.ValueSize() > ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil && writeErr == nil {
+				writeErr = err
+			}
+			batch.Reset()
+		}
+	}
+	for _, source := range sources {
+		srcdb, err := openDiskStore(backend, source)
+		if err != nil {
+			return fmt.Errorf("%s: %v", source, err)
+		}
+		src := rawdb.NewDatabase(srcdb)
+		head := readBenchHead(src)
+		switch {
+		case head == nil:
+			err = fmt.Errorf("no benchmark state found")
+		case head.Parts != uint64(len(sources)):
+			err = fmt.Errorf("part of a state in %d parts, %d given", head.Parts, len(sources))
+		case parts[head.Part] != "":
+			err = fmt.Errorf("same part %d/%d as %s", head.Part, head.Parts, parts[head.Part])
+		case merged != nil && (head.Accounts != merged.Accounts || head.Slots != merged.Slots || head.Block != merged.Block):
+			err = fmt.Errorf("built with different accounts, slots or blocks than %s", sources[0])
+		case merged != nil && (head.Seed != merged.Seed || head.Params != merged.Params):
+			err = fmt.Errorf("built with a different seed or workload (%s, seed %d) than %s (%s, seed %d)", head.Params, head.Seed, sources[0], merged.Params, merged.Seed)
+		}
+		if err != nil {
+			src.Close()
+			return fmt.Errorf("%s: %v", source, err)
+		}
+		parts[head.Part] = source
+		if merged == nil {
+			merged = &benchHead{Block: head.Block, Time: head.Time, Accounts: head.Accounts, Slots: head.Slots, Seed: head.Seed, Params: head.Params}
+		}
+		fmt.Printf("Importing part %d/%d from %s (root %x)...\n", head.Part, head.Parts, source, head.Root)
+
+		tdb := triedb.NewDatabase(src, triedb.HashDefaults)
+		accTrie, err := trie.New(trie.StateTrieID(head.Root), tdb)
+		if err != nil {
+			src.Close()
+			return err
+		}
+		it := accTrie.MustNodeIterator(nil)
+		for it.Next(true) {
+			if !it.Leaf() {
+				continue
+			}
+			leaves = append(leaves, leaf{common.CopyBytes(it.LeafKey()), common.CopyBytes(it.LeafBlob())})
+			var acc types.StateAccount
+			if err := rlp.DecodeBytes(it.LeafBlob(), &acc); err != nil {
+				src.Close()
+				return err
+			}
+			if codeHash := common.BytesToHash(acc.CodeHash); codeHash != types.EmptyCodeHash {
+				rawdb.WriteCode(batch, codeHash, rawdb.ReadCode(src, codeHash))
+			}
+			if acc.Root == types.EmptyRootHash || copied[acc.Root] {
+				continue
+			}
+			copied[acc.Root] = true
+			st, err := trie.New(trie.StorageTrieID(head.Root, common.BytesToHash(it.LeafKey()), acc.Root), tdb)
+			if err != nil {
+				src.Close()
+				return err
+			}
+			sit := st.MustNodeIterator(nil)
+			for sit.Next(true) {
+				if hash := sit.Hash(); hash != (common.Hash{}) {
+					blob := sit.NodeBlob()
+					rawdb.WriteLegacyTrieNode(batch, hash, blob)
+					nodes++
+					size += int64(len(blob))
+					write()
+				}
+			}
+			if sit.Error() != nil {
+				src.Close()
+				return sit.Error()
+			}
+			write()
+		}
+		err = it.Error()
+		tdb.Close()
+		src.Close()
+		if err != nil {
+			return err
+		}
+		if writeErr != nil {
+			return writeErr
+		}
+	}
+	copyTime := time.Since(start)
+
+	// The unified account trie, from the leaves of all parts
+	start = time.Now()
+	sort.Slice(leaves, func(a, b int) bool { return bytes.Compare(leaves[a].key, leaves[b].key) < 0 })
+	st := trie.NewStackTrie(func(path []byte, hash common.Hash, blob []byte) {
+		rawdb.WriteLegacyTrieNode(batch, hash, blob)
+		write()
+	})
+	for i, l := range leaves {
+		if i > 0 && bytes.Equal(l.key, leaves[i-1].key) {
+			return fmt.Errorf("account %x is in more than one part", l.key)
+		}
+		if err := st.Update(l.key, l.value); err != nil {
+			return err
+		}
+	}
+	merged.Root = st.Hash()
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+	writeBenchHead(diskdb, merged)
+	rebuildTime := time.Since(start)
+
+	// Verification: every node reachable, every account present
+	start = time.Now()
+	tdb := triedb.NewDatabase(diskdb, triedb.HashDefaults)
+	defer tdb.Close()
+	walked := make(map[common.Hash]struct{})
+	if err := walkStateNodes(tdb, merged.Root, func(_, hash common.Hash, _ []byte) bool {
+		if _, ok := walked[hash]; ok {
+			return false
+		}
+		walked[hash] = struct{}{}
+		return true
+	}); err != nil {
+		return fmt.Errorf("merged state incomplete: %v", err)
+	}
+	if uint64(len(leaves)) != merged.Accounts {
+		return fmt.Errorf("merged %d accounts, the state has %d", len(leaves), merged.Accounts)
+	}
+	fmt.Printf("\n--- Merge Report ---\n")
+	fmt.Printf("Parts:         %d, %d accounts at block %d\n", len(sources), len(leaves), merged.Block)
+	fmt.Printf("Storage Copy:  %d nodes (%.2f MB) of %d tries in %v (%.0f nodes/s, %.1f MB/s)\n", nodes, float64(size)/(1024*1024), len(copied),
+		copyTime, float64(nodes)/copyTime.Seconds(), float64(size)/(1024*1024)/copyTime.Seconds())
+	fmt.Printf("Account Trie:  rebuilt in %v (%.0f accounts/s)\n", rebuildTime, float64(len(leaves))/rebuildTime.Seconds())
+	fmt.Printf("Verification:  %d nodes walked in %v, OK\n", len(walked), time.Since(start))
+	fmt.Printf("Merged Root:   %x\n", merged.Root)
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestBulkLoadRoot checks that the StackTrie bulk load builds the same state
+// as creating it through the StateDB.
+func TestBulkLoadRoot(t *testing.T) {
+	for _, workload := range []string{"slots", "evm"} {
+		args := []string{"-n", "30", "-slots", "12", "-m", "0", "-k", "7", "-seed", "3", "-workload", workload}
+		want := benchRoot(t, runBench(t, append(args, "-db", filepath.Join(t.TempDir(), "db"))...), "Final Root:")
+		have := benchRoot(t, runBench(t, append(args, "-db", filepath.Join(t.TempDir(), "db"), "-bulk-load")...), "Final Root:")
+		if have != want {
+			t.Errorf("%s: bulk-loaded root %s, StateDB root %s", workload, have, want)
+		}
+	}
+}
+
+// TestMergeRoot checks that merging the parts of a sharded run gives the root
+// of the same run over the whole state, and that parts generated with another
+// seed are rejected.
+func TestMergeRoot(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		args = []string{"-n", "40", "-slots", "8", "-m", "10", "-k", "10"}
+	)
+	whole := benchRoot(t, runBench(t, append(args, "-seed", "7", "-db", filepath.Join(dir, "whole"))...), "Final New Root:")
+
+	var parts []string
+	for i := 0; i < 3; i++ {
+		part := filepath.Join(dir, fmt.Sprintf("part%d", i))
+		runBench(t, append(args, "-seed", "7", "-shard", fmt.Sprintf("%d/3", i), "-db", part)...)
+		parts = append(parts, part)
+	}
+	out := runBench(t, "merge", "-merge-from", strings.Join(parts, ","), "-db", filepath.Join(dir, "merged"))
+	if merged := benchRoot(t, out, "Merged Root:"); merged != whole {
+		t.Errorf("merged root %s, whole run root %s", merged, whole)
+	}
+
+	other := filepath.Join(dir, "other")
+	runBench(t, append(args, "-seed", "8", "-shard", "2/3", "-db", other)...)
+	out = runBench(t, "merge", "-merge-from", strings.Join([]string{parts[0], parts[1], other}, ","), "-db", filepath.Join(dir, "mixed"))
+	if strings.Contains(out, "Merged Root:") || !strings.Contains(out, "Merge failed") {
+		t.Errorf("parts of different seeds merged:\n%s", out)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// datasetMagic starts every file written by gen-dataset.
+var datasetMagic = []byte("MPTDATA1")
+
+// dataset streams the precomputed keys and values of a run, so that generating
+// them does not count towards the measured insertion times. The file holds a
+// header (accounts, slots, modified accounts, seed), the account addresses,
+// the slot keys and values shared by all accounts in Phase 1, and then per
+// modified account its index followed by its 500 slot key and value pairs.
+// Everything but the Phase 2 records is loaded upfront.
+type dataset struct {
+	accounts, slots, modified uint32
+	seed                      int64
+
+	addrs              []common.Address
+	slotKeys, slotVals []common.Hash
+
+	f  *os.File
+	in *bufio.Reader
+}
+
+// writeDataset generates the same keys and values a run with the given sizes,
+// seed and value entropy would, and writes them to path.
+func writeDataset(path string, accounts, slots, modified int, seed int64, entropy string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	out := bufio.NewWriterSize(f, 1<<20)
+	out.Write(datasetMagic)
+	binary.Write(out, binary.BigEndian, [3]uint32{uint32(accounts), uint32(slots), uint32(modified)})
+	binary.Write(out, binary.BigEndian, seed)
+	for i := 0; i < accounts; i++ {
+		out.Write(crypto.Keccak256([]byte(fmt.Sprintf("account-%d", i)))[:20])
+	}
+	for j := 0; j < slots; j++ {
+		out.Write(crypto.Keccak256([]byte(fmt.Sprintf("slot-%d", j))))
+		out.Write(slotValue(entropy, fmt.Sprintf("value-%d", j)).Bytes())
+	}
+	var (
+		r    = rand.New(rand.NewSource(seed))
+		perm = r.Perm(accounts)
+	)
+	for i := 0; i < modified; i++ {
+		binary.Write(out, binary.BigEndian, uint32(perm[i]))
+		for j := 0; j < 500; j++ {
+			out.Write(crypto.Keccak256([]byte(fmt.Sprintf("slot-%d", r.Intn(slots)))))
+			out.Write(slotValue(entropy, fmt.Sprintf("new-value-%d-%d", i, j)).Bytes())
+		}
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d accounts, %d slots each and %d modifications (seed=%d) to %s\n", accounts, slots, modified, seed, path)
+	return nil
+}
+
+func openDataset(path string) (*dataset, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	ds := &dataset{f: f, in: bufio.NewReaderSize(f, 1<<20)}
+	magic := make([]byte, len(datasetMagic))
+	if _, err := io.ReadFull(ds.in, magic); err != nil || !bytes.Equal(magic, datasetMagic) {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a dataset file", path)
+	}
+	var sizes [3]uint32
+	if err := binary.Read(ds.in, binary.BigEndian, &sizes); err != nil {
+		f.Close()
+		return nil, err
+	}
+	ds.accounts, ds.slots, ds.modified = sizes[0], sizes[1], sizes[2]
+	if err := binary.Read(ds.in, binary.BigEndian, &ds.seed); err != nil {
+		f.Close()
+		return nil, err
+	}
+	ds.addrs = make([]common.Address, ds.accounts)
+	for i := range ds.addrs {
+		if _, err := io.ReadFull(ds.in, ds.addrs[i][:]); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	ds.slotKeys, ds.slotVals = make([]common.Hash, ds.slots), make([]common.Hash, ds.slots)
+	for j := range ds.slotKeys {
+		if _, err := io.ReadFull(ds.in, ds.slotKeys[j][:]); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if _, err := io.ReadFull(ds.in, ds.slotVals[j][:]); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return ds, nil
+}
+
+// next reads the record of the next modified account: its index and its slot
+// writes as alternating keys and values.
+func (ds *dataset) next() (int, []common.Hash, error) {
+	var idx uint32
+	if err := binary.Read(ds.in, binary.BigEndian, &idx); err != nil {
+		return 0, nil, err
+	}
+	writes := make([]common.Hash, 1000)
+	for i := range writes {
+		if _, err := io.ReadFull(ds.in, writes[i][:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	return int(idx), writes, nil
+}
+
+func (ds *dataset) close() {
+	ds.f.Close()
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/trie/trienode"
+	"github.com/ethereum/go-ethereum/triedb"
+	"github.com/holiman/uint256"
+)
+
+// directTrie replays the Phase 1 and Phase 2 key/value streams against the
+// trie package directly: secure tries opened per account, updated, committed
+// into a merged node set and flushed through the trie database, without the
+// StateDB's object cache, journal or account abstraction. With the same seed
+// it produces the same final root as the StateDB run, and the time difference
+// between the two is the StateDB overhead.
+type directTrie struct {
+	trieDB *triedb.Database
+	root   common.Hash
+	block  uint64
+
+	accounts *trie.StateTrie
+	storage  map[common.Address]*trie.StateTrie // storage tries dirtied in the current batch
+	objects  map[common.Address]*types.StateAccount
+
+	// rootCache keeps every account seen so far, and with it its latest
+	// storage root, across batches. Trie handles cannot be carried over as
+	// Commit invalidates them, so storage tries are reopened from the cached
+	// root, but the account trie lookup is skipped. Nil disables the cache.
+	rootCache  map[common.Address]*types.StateAccount
+	lookups    int // account trie lookups in the modification phase
+	cacheHits  int
+	lookupTime time.Duration
+
+	createTime, modifyTime time.Duration
+	costs                  *hashCosts // nil unless hashing is timed separately
+}
+
+// Cost categories of a direct trie run.
+const (
+	costKeyGen   = iota // deriving workload keys and values with keccak
+	costKeyHash         // hashing slot keys inside the secure trie (estimated)
+	costUpdate          // trie reads and updates, key hashing included
+	costNodeHash        // encoding and hashing dirty nodes
+	costCollect         // collecting the hashed nodes into node sets
+	costDBWrite         // flushing node sets through the trie database
+	costKinds
+)
+
+// hashCosts accumulates the time spent per cost category. All methods are
+// no-ops on a nil receiver, so that runs without -hash-cost skip the timing.
+type hashCosts [costKinds]time.Duration
+
+func (c *hashCosts) start() time.Time {
+	if c == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+func (c *hashCosts) stop(kind int, start time.Time) {
+	if c != nil {
+		c[kind] += time.Since(start)
+	}
+}
+
+func (c *hashCosts) report() {
+	var total time.Duration
+	for kind, d := range c {
+		if kind != costKeyHash { // part of costUpdate
+			total += d
+		}
+	}
+	keccak := c[costKeyGen] + c[costKeyHash] + c[costNodeHash]
+	row := func(name string, d time.Duration) {
+		fmt.Printf("%-28s %14v %6.1f%%\n", name, d.Round(time.Millisecond), 100*float64(d)/float64(total))
+	}
+	fmt.Printf("\n--- Hashing vs Insertion ---\n")
+	row("Key derivation (keccak)", c[costKeyGen])
+	row("Key hashing (keccak, est.)", c[costKeyHash])
+	row("Trie manipulation", c[costUpdate]-c[costKeyHash])
+	row("Node hashing (keccak + RLP)", c[costNodeHash])
+	row("Node collection", c[costCollect])
+	row("Database writes", c[costDBWrite])
+	row("Keccak total", keccak)
+}
+
+func (dt *directTrie) open() error {
+	tr, err := trie.NewStateTrie(trie.StateTrieID(dt.root), dt.trieDB)
+	if err != nil {
+		return err
+	}
+	dt.accounts = tr
+	dt.storage = make(map[common.Address]*trie.StateTrie)
+	dt.objects = make(map[common.Address]*types.StateAccount)
+	return nil
+}
+
+// account returns the account at addr along with its storage trie, loading
+// both on first access within the batch.
+func (dt *directTrie) account(addr common.Address) (*types.StateAccount, *trie.StateTrie, error) {
+	if acc, ok := dt.objects[addr]; ok {
+		return acc, dt.storage[addr], nil
+	}
+	acc, ok := dt.rootCache[addr]
+	if ok {
+		dt.cacheHits++
+	} else {
+		start := time.Now()
+		var err error
+		if acc, err = dt.accounts.GetAccount(addr); err != nil {
+			return nil, nil, err
+		}
+		dt.lookups++
+		dt.lookupTime += time.Since(start)
+		if acc == nil {
+			acc = types.NewEmptyStateAccount()
+		}
+		if dt.rootCache != nil {
+			dt.rootCache[addr] = acc
+		}
+	}
+	id := trie.StorageTrieID(dt.root, crypto.Keccak256Hash(addr[:]), acc.Root)
+	st, err := trie.NewStateTrie(id, dt.trieDB)
+	if err != nil {
+		return nil, nil, err
+	}
+	dt.objects[addr], dt.storage[addr] = acc, st
+	return acc, st, nil
+}
+
+// setState reads the previous slot value, as the StateDB does for its
+// journal, and writes the new one.
+func (dt *directTrie) setState(st *trie.StateTrie, addr common.Address, key, value common.Hash) error {
+	if dt.costs != nil {
+		// The secure trie hashes the key on both the read and the write,
+		// which cannot be timed from the outside, so repeat it here.
+		start := time.Now()
+		crypto.Keccak256(key[:])
+		crypto.Keccak256(key[:])
+		dt.costs.stop(costKeyHash, start)
+	}
+	defer dt.costs.stop(costUpdate, dt.costs.start())
+	if _, err := st.GetStorage(addr, key[:]); err != nil {
+		return err
+	}
+	return st.UpdateStorage(addr, key[:], common.TrimLeftZeroes(value[:]))
+}
+
+// commit hashes all dirty storage tries and the account trie and flushes the
+// resulting nodes to disk.
+func (dt *directTrie) commit() error {
+	// Hashing upfront is redundant, Commit would do it, but it lets the
+	// node hashing be timed apart from the node collection.
+	commit := func(tr *trie.StateTrie) (common.Hash, *trienode.NodeSet) {
+		if dt.costs != nil {
+			start := time.Now()
+			tr.Hash()
+			dt.costs.stop(costNodeHash, start)
+		}
+		defer dt.costs.stop(costCollect, dt.costs.start())
+		return tr.Commit(false)
+	}
+	merged := trienode.NewMergedNodeSet()
+	for addr, st := range dt.storage {
+		root, set := commit(st)
+		if set != nil {
+			if err := merged.Merge(set); err != nil {
+				return err
+			}
+		}
+		acc := dt.objects[addr]
+		acc.Root = root
+		start := dt.costs.start()
+		if err := dt.accounts.UpdateAccount(addr, acc, 0); err != nil {
+			return err
+		}
+		dt.costs.stop(costUpdate, start)
+	}
+	root, set := commit(dt.accounts)
+	if set != nil {
+		if err := merged.Merge(set); err != nil {
+			return err
+		}
+	}
+	start := dt.costs.start()
+	if err := dt.trieDB.Update(root, dt.root, dt.block, merged, nil); err != nil {
+		return err
+	}
+	if err := dt.trieDB.Commit(root, false); err != nil {
+		return err
+	}
+	dt.costs.stop(costDBWrite, start)
+	dt.root = root
+	dt.block++
+	return dt.open()
+}
+
+func (dt *directTrie) run(nAccounts, nSlots, mModify, batchSize int, r *rand.Rand) error {
+	if err := dt.open(); err != nil {
+		return err
+	}
+	start := time.Now()
+	addrs := make([]common.Address, nAccounts)
+	for i := 0; i < nAccounts; i++ {
+		addrs[i] = common.BytesToAddress(crypto.Keccak256([]byte(fmt.Sprintf("account-%d", i)))[:20])
+		acc, st, err := dt.account(addrs[i])
+		if err != nil {
+			return err
+		}
+		acc.Balance = uint256.NewInt(1e18)
+		acc.Nonce = uint64(i)
+		for j := 0; j < nSlots; j++ {
+			start := dt.costs.start()
+			slotKey := common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("slot-%d", j))))
+			slotVal := common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("value-%d", j))))
+			dt.costs.stop(costKeyGen, start)
+			if err := dt.setState(st, addrs[i], slotKey, slotVal); err != nil {
+				return err
+			}
+		}
+		if (i+1)%batchSize == 0 || i+1 == nAccounts {
+			if err := dt.commit(); err != nil {
+				return err
+			}
+		}
+	}
+	dt.createTime = time.Since(start)
+	fmt.Printf("Creation finished in %v. Root: %x\n", dt.createTime, dt.root)
+
+	dt.lookups, dt.cacheHits, dt.lookupTime = 0, 0, 0
+	start = time.Now()
+	perm := r.Perm(nAccounts)
+	for i := 0; i < mModify; i++ {
+		addr := addrs[perm[i]]
+		_, st, err := dt.account(addr)
+		if err != nil {
+			return err
+		}
+		for j := 0; j < 500; j++ {
+			slotIdx := r.Intn(nSlots)
+			start := dt.costs.start()
+			slotKey := common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("slot-%d", slotIdx))))
+			newVal := common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("new-value-%d-%d", i, j))))
+			dt.costs.stop(costKeyGen, start)
+			if err := dt.setState(st, addr, slotKey, newVal); err != nil {
+				return err
+			}
+		}
+		if (i+1)%batchSize == 0 || i+1 == mModify {
+			if err := dt.commit(); err != nil {
+				return err
+			}
+		}
+	}
+	dt.modifyTime = time.Since(start)
+	fmt.Printf("Modification finished in %v. Root: %x\n", dt.modifyTime, dt.root)
+	return nil
+}
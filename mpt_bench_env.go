@@ -0,0 +1,442 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	vmruntime "github.com/ethereum/go-ethereum/core/vm/runtime"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/triedb"
+)
+
+// benchHeadKey is the database key under which the head of the benchmark
+// state history is recorded.
+var benchHeadKey = []byte("mpt-bench-head")
+
+// benchHead describes the state a benchmark run left behind, so that the
+// modify subcommand can continue its history with non-overlapping blocks.
+type benchHead struct {
+	Root     common.Hash
+	Block    uint64
+	Accounts uint64
+	Slots    uint64
+	Time     uint64 `rlp:"optional"` // timestamp of Block
+	Part     uint64 `rlp:"optional"` // index of the state shard built, see -shard
+	Parts    uint64 `rlp:"optional"` // number of state shards, 0 for a whole state
+	Seed     uint64 `rlp:"optional"` // seed of the run that created the state
+	Params   string `rlp:"optional"` // other flags of that run the state depends on
+}
+
+// statePart selects the accounts a run builds when the state is generated in
+// parts: every count-th account, starting with account index. Batches and
+// random draws still cover all accounts, so every part commits the same
+// blocks and each account ends up exactly as in a run building it whole.
+type statePart struct {
+	index, count int
+}
+
+// parseStatePart parses a part given as i/n; the empty string means the
+// whole state.
+func parseStatePart(s string) (statePart, error) {
+	if s == "" {
+		return statePart{}, nil
+	}
+	var part statePart
+	if _, err := fmt.Sscanf(s, "%d/%d", &part.index, &part.count); err != nil {
+		return part, fmt.Errorf("%q is not of the form i/n", s)
+	}
+	if part.count < 1 || part.index < 0 || part.index >= part.count {
+		return part, fmt.Errorf("%q is out of range", s)
+	}
+	return part, nil
+}
+
+func (p statePart) owns(account int) bool {
+	return p.count <= 1 || account%p.count == p.index
+}
+
+func readBenchHead(db ethdb.KeyValueReader) *benchHead {
+	blob, err := db.Get(benchHeadKey)
+	if err != nil || len(blob) == 0 {
+		return nil
+	}
+	head := new(benchHead)
+	if err := rlp.DecodeBytes(blob, head); err != nil {
+		return nil
+	}
+	return head
+}
+
+func writeBenchHead(db ethdb.KeyValueWriter, head *benchHead) {
+	blob, err := rlp.EncodeToBytes(head)
+	if err != nil {
+		panic(err)
+	}
+	if err := db.Put(benchHeadKey, blob); err != nil {
+		fmt.Printf("Failed to record benchmark head: %v\n", err)
+	}
+}
+
+// benchEnv bundles the database handles shared by all benchmark phases
+// together with the live statedb and the last committed root.
+type benchEnv struct {
+	trieDB  *triedb.Database
+	sdb     state.Database
+	statedb *state.StateDB
+	root    common.Hash
+	history []common.Hash // all committed roots, oldest first
+
+	reuse     bool               // keep one statedb per phase instead of reopening per batch
+	unbounded bool               // never hash, commit or collect until the end of the run
+	announce  bool               // print every committed root for crash-test
+	diffs     *stateDiffWriter   // nil unless state diffs are exported
+	flusher   *backgroundFlusher // nil if nodes are flushed to disk inline
+	chain     *simChain          // shared by all shards
+	pending   uint64             // block of the last batch not yet committed in reuse mode
+	dirty     bool
+	heap      []int // heap in MB after every batch
+}
+
+// simChain is the simulated chain the benchmark commits its batches as. Every
+// batch seals one block, numbered consecutively and stamped -block-time after
+// its parent. The last block is recorded in the benchmark head, so that runs
+// continuing a database extend one coherent chain.
+type simChain struct {
+	number uint64 // block being built
+	time   uint64 // its timestamp
+	period uint64 // seconds between blocks
+}
+
+func (c *simChain) seal() {
+	c.number++
+	c.time += c.period
+}
+
+// skip moves ahead to building the given block, as if the blocks in between
+// had been empty.
+func (c *simChain) skip(number uint64) {
+	c.time += (number - c.number) * c.period
+	c.number = number
+}
+
+// last returns the number and timestamp of the last sealed block.
+func (c *simChain) last() (uint64, uint64) {
+	if c.number == 0 {
+		return 0, 0
+	}
+	return c.number - 1, c.time - c.period
+}
+
+// endBlock commits the batch as the block being built and seals it.
+func (env *benchEnv) endBlock() error {
+	if err := env.commit(env.chain.number); err != nil {
+		return err
+	}
+	env.chain.seal()
+	return nil
+}
+
+// commit ends a batch. By default it flushes the batch to disk; in reuse mode
+// the statedb only hashes its changes, like at the end of a block, and keeps
+// accumulating them until flush is called.
+//
+// go-ethereum's StateDB is not usable after Commit, so keeping one instance
+// across batches necessarily defers the commit to the end of the phase.
+func (env *benchEnv) commit(block uint64) error {
+	defer env.sampleHeap()
+	if env.reuse {
+		if !env.unbounded {
+			env.root = env.statedb.IntermediateRoot(false)
+		}
+		env.pending, env.dirty = block, true
+		return nil
+	}
+	return env.flush(block)
+}
+
+// sampleHeap records the allocated heap, without forcing a collection that
+// would hide the garbage left behind by either statedb lifetime.
+func (env *benchEnv) sampleHeap() {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	env.heap = append(env.heap, int(stats.HeapAlloc/(1024*1024)))
+}
+
+// flush writes the pending changes of the statedb as the given block, flushes
+// the resulting trie nodes to disk and re-opens the statedb at the new root.
+func (env *benchEnv) flush(block uint64) error {
+	env.dirty = false
+	root, err := env.statedb.Commit(block, false, false)
+	if err != nil {
+		return fmt.Errorf("statedb commit: %v", err)
+	}
+	// In reuse mode env.root already moved on to the intermediate root of the
+	// last batch, so the parent is the root of the last flush. The diff is
+	// taken before flushing, while pathdb still holds the parent layer.
+	var parent common.Hash
+	if n := len(env.history); n > 0 {
+		parent = env.history[n-1]
+	}
+	if env.diffs != nil {
+		if err := env.diffs.write(block, parent, root); err != nil {
+			return fmt.Errorf("state diff: %v", err)
+		}
+	}
+	switch {
+	case root == parent:
+		// A batch that changed nothing ends at the last flushed root, which
+		// pathdb refuses to commit again as it already is its disk layer.
+	case env.flusher != nil:
+		env.flusher.committed(block, root)
+	default:
+		if err := env.trieDB.Commit(root, false); err != nil {
+			return fmt.Errorf("triedb commit: %v", err)
+		}
+	}
+	env.root = root
+	env.history = append(env.history, root)
+	if env.announce && env.flusher == nil {
+		fmt.Printf("[Committed] block=%d root=%s\n", block, root.Hex())
+	}
+	// Re-create statedb from the new root to release memory of dirty objects
+	env.statedb, err = state.New(root, env.sdb)
+	if err != nil {
+		return fmt.Errorf("statedb reopen: %v", err)
+	}
+	runtime.GC() // Suggest GC to clean up
+	return nil
+}
+
+// flushShards commits the changes that shards in reuse mode accumulated over
+// a phase, as the block of their last batch. Unbounded shards only commit at
+// the end of the run.
+func flushShards(shards []*benchEnv, final bool) error {
+	for s, shard := range shards {
+		if !shard.dirty || (shard.unbounded && !final) {
+			continue
+		}
+		if err := shard.flush(shard.pending); err != nil {
+			return fmt.Errorf("shard %d: %v", s, err)
+		}
+	}
+	return nil
+}
+
+func reportHeap(mode string, shards []*benchEnv) {
+	var heap []int
+	for _, shard := range shards {
+		heap = append(heap, shard.heap...)
+	}
+	fmt.Printf("Heap (%s): %s MB after each batch\n", mode, distribution(heap))
+}
+
+// shardCommits commits a set of independent state shards one after the other
+// and records the latency of each individual shard commit, as well as of the
+// whole batch.
+type shardCommits struct {
+	shard []int // µs per shard commit
+	batch []int // µs per batch, summed over all shards
+	laps  []int // ms from the end of one batch to the end of the next
+	last  time.Time
+}
+
+// phase starts a new phase, whose batch times are measured from now.
+func (sc *shardCommits) phase() {
+	sc.laps, sc.last = nil, time.Now()
+}
+
+// commit commits all shards as the block being built and seals it.
+func (sc *shardCommits) commit(shards []*benchEnv) error {
+	var (
+		total time.Duration
+		chain = shards[0].chain
+	)
+	for s, shard := range shards {
+		start := time.Now()
+		if err := shard.commit(chain.number); err != nil {
+			return fmt.Errorf("shard %d: %v", s, err)
+		}
+		elapsed := time.Since(start)
+		sc.shard = append(sc.shard, int(elapsed.Microseconds()))
+		total += elapsed
+	}
+	sc.batch = append(sc.batch, int(total.Microseconds()))
+	chain.seal()
+	if !sc.last.IsZero() {
+		sc.laps = append(sc.laps, int(time.Since(sc.last).Milliseconds()))
+	}
+	sc.last = time.Now()
+	return nil
+}
+
+func (sc *shardCommits) report() {
+	fmt.Printf("Shard Commit:  %s µs\n", distribution(sc.shard))
+	fmt.Printf("Batch Commit:  %s µs\n", distribution(sc.batch))
+	fmt.Printf("Batch Time:    %s ms (last phase, work included)\n", distribution(sc.laps))
+}
+
+// backgroundFlusher writes committed trie nodes to disk from its own
+// goroutine, on a timer or once the unflushed nodes exceed a size, while the
+// workload only commits into the trie database. Roots are flushed oldest
+// first and none is dropped, as nothing is dereferenced: every root in the
+// history stays readable, from memory until its flush and from disk after.
+// Flushes hold the trie database lock, so the workload still stalls when it
+// commits during one; how much is what the batch times show.
+type backgroundFlusher struct {
+	trieDB   *triedb.Database
+	interval time.Duration
+	limit    common.StorageSize
+	announce bool
+
+	lock    sync.Mutex // protects pending
+	pending []flushedRoot
+	flush   sync.Mutex // serializes flushes
+	times   []int      // ms per flush
+	roots   []int      // roots written per flush
+
+	stop chan struct{}
+	done chan error
+}
+
+type flushedRoot struct {
+	block uint64
+	root  common.Hash
+}
+
+func newBackgroundFlusher(trieDB *triedb.Database, interval time.Duration, limitMB int, announce bool) *backgroundFlusher {
+	f := &backgroundFlusher{
+		trieDB:   trieDB,
+		interval: interval,
+		limit:    common.StorageSize(limitMB) * 1024 * 1024,
+		announce: announce,
+		stop:     make(chan struct{}),
+		done:     make(chan error, 1),
+	}
+	go f.loop()
+	return f
+}
+
+// committed queues a root the statedb committed into the trie database.
+func (f *backgroundFlusher) committed(block uint64, root common.Hash) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.pending = append(f.pending, flushedRoot{block, root})
+}
+
+func (f *backgroundFlusher) loop() {
+	poll := f.interval
+	if f.limit > 0 && (poll == 0 || poll > 10*time.Millisecond) {
+		poll = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-f.stop:
+			f.done <- f.sync()
+			return
+		case <-ticker.C:
+			due := f.interval > 0 && time.Since(last) >= f.interval
+			if !due && f.limit > 0 {
+				_, nodes, _ := f.trieDB.Size()
+				due = nodes >= f.limit
+			}
+			if !due {
+				continue
+			}
+			if err := f.sync(); err != nil {
+				f.done <- err
+				return
+			}
+			last = time.Now()
+		}
+	}
+}
+
+// sync flushes all queued roots to disk, oldest first.
+func (f *backgroundFlusher) sync() error {
+	if f == nil {
+		return nil
+	}
+	f.flush.Lock()
+	defer f.flush.Unlock()
+
+	f.lock.Lock()
+	pending := f.pending
+	f.pending = nil
+	f.lock.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	start := time.Now()
+	for _, p := range pending {
+		if err := f.trieDB.Commit(p.root, false); err != nil {
+			return fmt.Errorf("triedb commit: %v", err)
+		}
+		if f.announce {
+			fmt.Printf("[Committed] block=%d root=%s\n", p.block, p.root.Hex())
+		}
+	}
+	f.times = append(f.times, int(time.Since(start).Milliseconds()))
+	f.roots = append(f.roots, len(pending))
+	return nil
+}
+
+// close stops the flusher after flushing everything still queued.
+func (f *backgroundFlusher) close() error {
+	if f == nil {
+		return nil
+	}
+	close(f.stop)
+	return <-f.done
+}
+
+func (f *backgroundFlusher) report() {
+	fmt.Printf("Bg. Flushes:   %d, %s ms each, %s roots each\n", len(f.times), distribution(f.times), distribution(f.roots))
+}
+
+// call executes a single simulated transaction against the contract at addr
+// on top of the live statedb and returns the gas it used, including the
+// intrinsic transaction cost. It executes in the block being built.
+func (env *benchEnv) call(addr common.Address, input []byte) (uint64, error) {
+	cfg := &vmruntime.Config{
+		BlockNumber: new(big.Int).SetUint64(env.chain.number),
+		Time:        env.chain.time,
+		GasLimit:    params.MaxGasLimit,
+		State:       env.statedb,
+	}
+	_, left, err := vmruntime.Call(addr, input, cfg)
+	if err != nil {
+		return 0, err
+	}
+	return intrinsicGas(input, false) + cfg.GasLimit - left, nil
+}
+
+// create deploys a contract running the given init code, either from a plain
+// CREATE transaction or through the CREATE2 factory, and returns the gas used.
+func (env *benchEnv) create(initcode []byte, create2 bool) (uint64, error) {
+	if create2 {
+		return env.call(create2Factory, initcode)
+	}
+	cfg := &vmruntime.Config{
+		Origin:      deployer,
+		BlockNumber: new(big.Int).SetUint64(env.chain.number),
+		Time:        env.chain.time,
+		GasLimit:    params.MaxGasLimit,
+		State:       env.statedb,
+	}
+	_, _, left, err := vmruntime.Create(initcode, cfg)
+	if err != nil {
+		return 0, err
+	}
+	return intrinsicGas(initcode, true) + cfg.GasLimit - left, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestParseStatePart(t *testing.T) {
+	tests := []struct {
+		in   string
+		want statePart
+		err  bool
+	}{
+		{in: "", want: statePart{}},
+		{in: "0/1", want: statePart{0, 1}},
+		{in: "0/4", want: statePart{0, 4}},
+		{in: "3/4", want: statePart{3, 4}},
+		{in: "4/4", err: true},
+		{in: "-1/4", err: true},
+		{in: "0/0", err: true},
+		{in: "1", err: true},
+		{in: "a/b", err: true},
+	}
+	for _, tt := range tests {
+		have, err := parseStatePart(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: have error %v, want error %t", tt.in, err, tt.err)
+			continue
+		}
+		if err == nil && have != tt.want {
+			t.Errorf("%q: have %+v, want %+v", tt.in, have, tt.want)
+		}
+	}
+}
+
+// TestStatePartOwns checks that the parts of a state own every account
+// exactly once, and that a whole state owns all of them.
+func TestStatePartOwns(t *testing.T) {
+	for _, count := range []int{1, 2, 3, 7} {
+		for account := 0; account < 50; account++ {
+			owners := 0
+			for index := 0; index < count; index++ {
+				if (statePart{index, count}).owns(account) {
+					owners++
+				}
+			}
+			if owners != 1 {
+				t.Errorf("account %d owned by %d of %d parts", account, owners, count)
+			}
+		}
+	}
+	if !(statePart{}).owns(42) {
+		t.Errorf("whole state does not own account 42")
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// storageWriterCode is the runtime code installed on every account by the evm
+// workload. It treats the calldata as a sequence of 64-byte (slot, value)
+// pairs and SSTOREs each of them:
+//
+//	PUSH1 0; loop: JUMPDEST; DUP1; CALLDATASIZE; GT; ISZERO; PUSH1 end; JUMPI
+//	DUP1; PUSH1 32; ADD; CALLDATALOAD; DUP2; CALLDATALOAD; SSTORE
+//	PUSH1 64; ADD; PUSH1 loop; JUMP; end: JUMPDEST; STOP
+var storageWriterCode = common.FromHex("0x60005b8036111560185780602001358135556040016002565b00")
+
+// selfdestructCode is the runtime code of the contracts deployed by the
+// selfdestruct workload: CALLER; SELFDESTRUCT.
+var selfdestructCode = common.FromHex("0x33ff")
+
+// create2FactoryCode is the runtime code of the factory used for CREATE2
+// deployments. It bumps a deployment counter in slot 0 and deploys its
+// calldata as init code, using the previous counter value as salt:
+//
+//	PUSH1 0; SLOAD; DUP1; PUSH1 1; ADD; PUSH1 0; SSTORE
+//	CALLDATASIZE; PUSH1 0; PUSH1 0; CALLDATACOPY
+//	CALLDATASIZE; PUSH1 0; PUSH1 0; CREATE2; STOP
+var create2FactoryCode = common.FromHex("0x600054806001016000553660006000373660006000f500")
+
+// create2Factory is the address the CREATE2 factory is installed at.
+var create2Factory = common.BytesToAddress(crypto.Keccak256([]byte("create2-factory"))[:20])
+
+// deployer is the sender of the CREATE transactions of the evm workload.
+var deployer = common.BytesToAddress(crypto.Keccak256([]byte("deployer"))[:20])
+
+// codeStats hands out the runtime codes deployed by the evm workload and keeps
+// track of how often each was deployed.
+type codeStats struct {
+	variants [][]byte
+	deployed []int // deployments per variant
+}
+
+// newCodeStats prepares n runtime code variants. All of them run the storage
+// writer; all but the first are followed by unreachable padding that gives
+// them distinct hashes and sizes: a third are minimal-proxy sized, the rest
+// spread up to the EIP-170 limit.
+func newCodeStats(n int) *codeStats {
+	cs := &codeStats{variants: make([][]byte, n), deployed: make([]int, n)}
+	for v := range cs.variants {
+		if v == 0 {
+			cs.variants[v] = storageWriterCode
+			continue
+		}
+		size := 45
+		if v%3 != 0 {
+			size = len(storageWriterCode) + v*7919%(params.MaxCodeSize-len(storageWriterCode))
+		}
+		code := make([]byte, max(size, len(storageWriterCode)+4))
+		copy(code, storageWriterCode)
+		binary.BigEndian.PutUint32(code[len(storageWriterCode):], uint32(v))
+		cs.variants[v] = code
+	}
+	return cs
+}
+
+// pick selects a code variant with an exponential popularity skew towards
+// the first variants, as a few templates dominate real deployments.
+func (cs *codeStats) pick(r *rand.Rand) []byte {
+	v := int(r.ExpFloat64()*float64(len(cs.variants))/4) % len(cs.variants)
+	cs.deployed[v]++
+	return cs.variants[v]
+}
+
+func (cs *codeStats) report() {
+	var (
+		buckets  = []int{256, 1024, 4096, 16384, params.MaxCodeSize}
+		hist     = make([]int, len(buckets))
+		unique   int
+		total    int
+		deployed int64
+		stored   int64
+	)
+	for v, n := range cs.deployed {
+		if n == 0 {
+			continue
+		}
+		unique++
+		total += n
+		deployed += int64(n * len(cs.variants[v]))
+		stored += int64(len(cs.variants[v]))
+		for b, limit := range buckets {
+			if len(cs.variants[v]) <= limit {
+				hist[b] += n
+				break
+			}
+		}
+	}
+	fmt.Printf("Unique Code:   %d hashes for %d contracts (%.1fx deduplication)\n", unique, total, float64(total)/float64(max(unique, 1)))
+	fmt.Printf("Code Bytes:    %.2f MB deployed, %.2f MB stored\n", float64(deployed)/(1024*1024), float64(stored)/(1024*1024))
+	lower := 0
+	for b, limit := range buckets {
+		fmt.Printf("  %6d-%-6d %d\n", lower, limit, hist[b])
+		lower = limit + 1
+	}
+}
+
+// initCode assembles init code that stores the given values into slots 0..n-1
+// and returns the runtime code as the deployed contract code.
+func initCode(ctor []common.Hash, runtimeCode []byte) []byte {
+	var code []byte
+	for i, val := range ctor {
+		code = append(code, byte(vm.PUSH32))
+		code = append(code, val[:]...)
+		code = append(code, byte(vm.PUSH2), byte(i>>8), byte(i), byte(vm.SSTORE))
+	}
+	// PUSH2 len; PUSH2 offset; PUSH1 0; CODECOPY; PUSH2 len; PUSH1 0; RETURN
+	offset := len(code) + 15
+	size := len(runtimeCode)
+	code = append(code,
+		byte(vm.PUSH2), byte(size>>8), byte(size),
+		byte(vm.PUSH2), byte(offset>>8), byte(offset),
+		byte(vm.PUSH1), 0, byte(vm.CODECOPY),
+		byte(vm.PUSH2), byte(size>>8), byte(size),
+		byte(vm.PUSH1), 0, byte(vm.RETURN),
+	)
+	return append(code, runtimeCode...)
+}
+
+// intrinsicGas returns the base cost of a transaction carrying the given
+// calldata, as charged before any code is executed.
+func intrinsicGas(data []byte, creation bool) uint64 {
+	gas := params.TxGas
+	if creation {
+		gas = params.TxGasContractCreation + params.InitCodeWordGas*uint64((len(data)+31)/32)
+	}
+	for _, b := range data {
+		if b == 0 {
+			gas += params.TxDataZeroGas
+		} else {
+			gas += params.TxDataNonZeroGasEIP2028
+		}
+	}
+	return gas
+}
+
+// gasMeter accumulates gas used and wall time of simulated blocks.
+type gasMeter struct {
+	blocks  int
+	gas     uint64
+	elapsed time.Duration
+}
+
+func (m *gasMeter) add(gas uint64, elapsed time.Duration) {
+	m.blocks++
+	m.gas += gas
+	m.elapsed += elapsed
+}
+
+func (m *gasMeter) report() {
+	if m.blocks == 0 {
+		return
+	}
+	fmt.Printf("Blocks:        %d\n", m.blocks)
+	fmt.Printf("Gas Used:      %d (avg %d per block)\n", m.gas, m.gas/uint64(m.blocks))
+	fmt.Printf("Throughput:    %.2f Mgas/s\n", mgasPerSec(m.gas, m.elapsed))
+}
+
+func mgasPerSec(gas uint64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(gas) / 1e6 / elapsed.Seconds()
+}
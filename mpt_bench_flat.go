@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/holiman/uint256"
+)
+
+// Key prefixes of the flat state store.
+var (
+	flatAccountPrefix = []byte("A") // A + address -> slim account RLP
+	flatStoragePrefix = []byte("S") // S + address + slot -> trimmed value
+)
+
+// flatState is an Erigon-style plain state store: accounts and storage slots
+// live in sorted key-value tables under their unhashed keys, and the state
+// root is not maintained while writing. It is computed once at the end by
+// hashing the keys, sorting them and streaming them through StackTries.
+type flatState struct {
+	db    ethdb.KeyValueStore
+	batch ethdb.Batch
+
+	root                             common.Hash
+	createTime, modifyTime, rootTime time.Duration
+	size                             int64
+}
+
+func flatStorageKey(addr common.Address, slot common.Hash) []byte {
+	return append(append(append([]byte{}, flatStoragePrefix...), addr[:]...), slot[:]...)
+}
+
+// setState reads the previous value, as the StateDB does for its journal,
+// and stages the new one.
+func (fs *flatState) setState(addr common.Address, slot, value common.Hash) error {
+	key := flatStorageKey(addr, slot)
+	fs.db.Get(key) // a missing key is the expected outcome for fresh slots
+	return fs.batch.Put(key, common.TrimLeftZeroes(value[:]))
+}
+
+func (fs *flatState) setAccount(addr common.Address, acc *types.StateAccount) error {
+	return fs.batch.Put(append(append([]byte{}, flatAccountPrefix...), addr[:]...), types.SlimAccountRLP(*acc))
+}
+
+func (fs *flatState) commit() error {
+	if err := fs.batch.Write(); err != nil {
+		return err
+	}
+	fs.batch.Reset()
+	return nil
+}
+
+// run replays the Phase 1 and Phase 2 key/value streams of the slots workload
+// and computes the state root afterwards.
+func (fs *flatState) run(nAccounts, nSlots, mModify, batchSize int, r *rand.Rand) error {
+	fs.batch = fs.db.NewBatch()
+	start := time.Now()
+	addrs := make([]common.Address, nAccounts)
+	for i := 0; i < nAccounts; i++ {
+		addrs[i] = common.BytesToAddress(crypto.Keccak256([]byte(fmt.Sprintf("account-%d", i)))[:20])
+		acc := types.NewEmptyStateAccount()
+		acc.Balance = uint256.NewInt(1e18)
+		acc.Nonce = uint64(i)
+		if err := fs.setAccount(addrs[i], acc); err != nil {
+			return err
+		}
+		for j := 0; j < nSlots; j++ {
+			slotKey := common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("slot-%d", j))))
+			slotVal := common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("value-%d", j))))
+			if err := fs.setState(addrs[i], slotKey, slotVal); err != nil {
+				return err
+			}
+		}
+		if (i+1)%batchSize == 0 || i+1 == nAccounts {
+			if err := fs.commit(); err != nil {
+				return err
+			}
+		}
+	}
+	fs.createTime = time.Since(start)
+
+	start = time.Now()
+	perm := r.Perm(nAccounts)
+	for i := 0; i < mModify; i++ {
+		addr := addrs[perm[i]]
+		for j := 0; j < 500; j++ {
+			slotIdx := r.Intn(nSlots)
+			slotKey := common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("slot-%d", slotIdx))))
+			newVal := common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("new-value-%d-%d", i, j))))
+			if err := fs.setState(addr, slotKey, newVal); err != nil {
+				return err
+			}
+		}
+		if (i+1)%batchSize == 0 || i+1 == mModify {
+			if err := fs.commit(); err != nil {
+				return err
+			}
+		}
+	}
+	fs.modifyTime = time.Since(start)
+
+	start = time.Now()
+	root, err := fs.computeRoot()
+	if err != nil {
+		return err
+	}
+	fs.root, fs.rootTime = root, time.Since(start)
+	return nil
+}
+
+// computeRoot derives the MPT root of the flat state. Keys are stored plain,
+// so the hashed trie order has to be established by sorting in memory.
+func (fs *flatState) computeRoot() (common.Hash, error) {
+	type kv struct {
+		key, value []byte
+	}
+	var accounts []kv
+
+	it := fs.db.NewIterator(flatAccountPrefix, nil)
+	defer it.Release()
+	for it.Next() {
+		addr := common.BytesToAddress(it.Key()[len(flatAccountPrefix):])
+		acc, err := types.FullAccount(it.Value())
+		if err != nil {
+			return common.Hash{}, err
+		}
+		var slots []kv
+		sit := fs.db.NewIterator(append(append([]byte{}, flatStoragePrefix...), addr[:]...), nil)
+		for sit.Next() {
+			value, _ := rlp.EncodeToBytes(common.CopyBytes(sit.Value()))
+			slots = append(slots, kv{crypto.Keccak256(sit.Key()[len(flatStoragePrefix)+common.AddressLength:]), value})
+		}
+		sit.Release()
+		if err := sit.Error(); err != nil {
+			return common.Hash{}, err
+		}
+		sort.Slice(slots, func(a, b int) bool { return bytes.Compare(slots[a].key, slots[b].key) < 0 })
+
+		st := trie.NewStackTrie(nil)
+		for _, slot := range slots {
+			if err := st.Update(slot.key, slot.value); err != nil {
+				return common.Hash{}, err
+			}
+		}
+		acc.Root = st.Hash()
+		value, _ := rlp.EncodeToBytes(acc)
+		accounts = append(accounts, kv{crypto.Keccak256(addr[:]), value})
+	}
+	if err := it.Error(); err != nil {
+		return common.Hash{}, err
+	}
+	sort.Slice(accounts, func(a, b int) bool { return bytes.Compare(accounts[a].key, accounts[b].key) < 0 })
+
+	st := trie.NewStackTrie(nil)
+	for _, acc := range accounts {
+		if err := st.Update(acc.key, acc.value); err != nil {
+			return common.Hash{}, err
+		}
+	}
+	return st.Hash(), nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestFlatRoot checks that the flat store computes the same root as the MPT
+// run it replays.
+func TestFlatRoot(t *testing.T) {
+	tests := [][]string{
+		{"-n", "25", "-slots", "10", "-m", "12", "-k", "5", "-seed", "5"},
+		{"-n", "40", "-slots", "1", "-m", "40", "-k", "3", "-seed", "6"},
+	}
+	for _, args := range tests {
+		out := runBench(t, append(args, "-flat", "-db", filepath.Join(t.TempDir(), "db"))...)
+		if !strings.Contains(out, "Roots Match:   true") {
+			t.Errorf("%v: flat root differs from the MPT root:\n%s", args, out)
+		}
+	}
+	// Deployments are not replayed against the flat store, so their root
+	// could never match.
+	out := runBench(t, "-n", "5", "-slots", "2", "-workload", "evm", "-creates", "1", "-flat", "-db", filepath.Join(t.TempDir(), "db"))
+	if strings.Contains(out, "Roots Match:") || !strings.Contains(out, "without -creates") {
+		t.Errorf("-flat with -creates not rejected:\n%s", out)
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/leveldb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/triedb/database"
+)
+
+// coldStore is a minimal append-only node store in the spirit of the freezer:
+// blobs are appended to a single data file and located through an index.
+// go-ethereum's freezer only accepts its predefined chain and state history
+// tables, which is why the experiment brings its own.
+type coldStore struct {
+	data  *os.File
+	index map[common.Hash][2]int64 // offset and length in the data file
+	size  int64
+}
+
+func newColdStore(dir string) (*coldStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	data, err := os.OpenFile(filepath.Join(dir, "nodes.cdat"), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &coldStore{data: data, index: make(map[common.Hash][2]int64)}, nil
+}
+
+func (cs *coldStore) append(hash common.Hash, blob []byte) error {
+	if _, err := cs.data.Write(blob); err != nil {
+		return err
+	}
+	cs.index[hash] = [2]int64{cs.size, int64(len(blob))}
+	cs.size += int64(len(blob))
+	return nil
+}
+
+func (cs *coldStore) get(hash common.Hash) []byte {
+	loc, ok := cs.index[hash]
+	if !ok {
+		return nil
+	}
+	blob := make([]byte, loc[1])
+	if _, err := cs.data.ReadAt(blob, loc[0]); err != nil {
+		return nil
+	}
+	return blob
+}
+
+// tieredNodeDB serves trie nodes from the live key-value store first and falls
+// back to the cold store, implementing database.NodeDatabase so that tries of
+// historical roots can be opened on top of it.
+type tieredNodeDB struct {
+	live ethdb.KeyValueReader
+	cold *coldStore
+}
+
+func (db *tieredNodeDB) NodeReader(root common.Hash) (database.NodeReader, error) {
+	return db, nil
+}
+
+func (db *tieredNodeDB) Node(owner common.Hash, path []byte, hash common.Hash) ([]byte, error) {
+	if blob := rawdb.ReadLegacyTrieNode(db.live, hash); len(blob) > 0 {
+		return blob, nil
+	}
+	if blob := db.cold.get(hash); len(blob) > 0 {
+		return blob, nil
+	}
+	return nil, fmt.Errorf("missing trie node %x", hash)
+}
+
+// walkStateNodes visits every node hash of the state at root, including all
+// storage tries, along with the owning account hash of storage trie nodes.
+// Subtries for which visit returns false are not descended.
+func walkStateNodes(db database.NodeDatabase, root common.Hash, visit func(owner, hash common.Hash, blob []byte) bool) error {
+	tr, err := trie.New(trie.StateTrieID(root), db)
+	if err != nil {
+		return err
+	}
+	it := tr.MustNodeIterator(nil)
+	for descend := true; it.Next(descend); {
+		descend = true
+		if hash := it.Hash(); hash != (common.Hash{}) {
+			descend = visit(common.Hash{}, hash, it.NodeBlob())
+		}
+		if !it.Leaf() {
+			continue
+		}
+		var acc types.StateAccount
+		if err := rlp.DecodeBytes(it.LeafBlob(), &acc); err != nil {
+			return err
+		}
+		if acc.Root == types.EmptyRootHash {
+			continue
+		}
+		owner := common.BytesToHash(it.LeafKey())
+		st, err := trie.New(trie.StorageTrieID(root, owner, acc.Root), db)
+		if err != nil {
+			return err
+		}
+		sit := st.MustNodeIterator(nil)
+		for sdescend := true; sit.Next(sdescend); {
+			sdescend = true
+			if hash := sit.Hash(); hash != (common.Hash{}) {
+				sdescend = visit(owner, hash, sit.NodeBlob())
+			}
+		}
+		if sit.Error() != nil {
+			return sit.Error()
+		}
+	}
+	return it.Error()
+}
+
+// clusteredNodeDB serves the trie nodes of a state copied into a standalone
+// database. Nodes are keyed by their hash as in the hash scheme, except that
+// in the clustered layout storage trie nodes are prefixed with the hash of
+// their account like the path scheme does, so that the storage trie of a
+// contract lies contiguously on disk instead of spread over the keyspace.
+type clusteredNodeDB struct {
+	db        ethdb.KeyValueStore
+	clustered bool
+}
+
+func (db *clusteredNodeDB) key(owner, hash common.Hash) []byte {
+	if db.clustered && owner != (common.Hash{}) {
+		return append(owner.Bytes(), hash.Bytes()...)
+	}
+	return hash.Bytes()
+}
+
+func (db *clusteredNodeDB) NodeReader(root common.Hash) (database.NodeReader, error) {
+	return db, nil
+}
+
+func (db *clusteredNodeDB) Node(owner common.Hash, path []byte, hash common.Hash) ([]byte, error) {
+	blob, err := db.db.Get(db.key(owner, hash))
+	if err != nil || len(blob) == 0 {
+		return nil, fmt.Errorf("missing trie node %x", hash)
+	}
+	return blob, nil
+}
+
+// clusterResult compares the global and the clustered layout.
+type clusterResult struct {
+	layouts []string
+	nodes   []int
+	sizes   []int64
+	reads   [][]int // latency in ns of reading 8 slots of one account
+}
+
+// compareStorageClustering copies the final state into two fresh LevelDB
+// instances next to the benchmark database, one per layout, compacts both and
+// then times the same random queries against each: 8 random slots of one
+// random account, as a transaction touching a contract reads them. Storage
+// tries shared by several accounts are stored once in the global layout but
+// once per account when clustered, which the node counts show.
+//
+// Both copies were just written, so their files may sit in the page cache;
+// the LevelDB block caches are kept small so that lookups at least go through
+// the table files.
+func compareStorageClustering(env *benchEnv, dbPath string, addrs []common.Address, nSlots, queries int, r *rand.Rand) (*clusterResult, error) {
+	res := &clusterResult{layouts: []string{"global", "clustered"}}
+	dbs := make([]*clusteredNodeDB, len(res.layouts))
+	for i, layout := range res.layouts {
+		path := dbPath + "-" + layout
+		os.RemoveAll(path)
+		db, err := leveldb.New(path, 16, 256, "eth/db/"+layout+"/", false)
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(path)
+		defer db.Close()
+		dbs[i] = &clusteredNodeDB{db: db, clustered: layout == "clustered"}
+	}
+	var (
+		batches = []ethdb.Batch{dbs[0].db.NewBatch(), dbs[1].db.NewBatch()}
+		stored  = []map[string]struct{}{make(map[string]struct{}), make(map[string]struct{})}
+		werr    error
+	)
+	res.nodes = make([]int, len(dbs))
+	err := walkStateNodes(env.trieDB, env.root, func(owner, hash common.Hash, blob []byte) bool {
+		descend := false
+		for i, db := range dbs {
+			key := db.key(owner, hash)
+			if _, ok := stored[i][string(key)]; ok {
+				continue
+			}
+			stored[i][string(key)] = struct{}{}
+			descend = true
+			res.nodes[i]++
+			if err := batches[i].Put(key, blob); err != nil && werr == nil {
+				werr = err
+			}
+			if batches[i].ValueSize() > ethdb.IdealBatchSize {
+				if err := batches[i].Write(); err != nil && werr == nil {
+					werr = err
+				}
+				batches[i].Reset()
+			}
+		}
+		return descend
+	})
+	if err != nil {
+		return nil, err
+	}
+	if werr != nil {
+		return nil, werr
+	}
+	for i, db := range dbs {
+		if err := batches[i].Write(); err != nil {
+			return nil, err
+		}
+		if err := db.db.Compact(nil, nil); err != nil {
+			return nil, err
+		}
+		res.sizes = append(res.sizes, getDirSize(dbPath+"-"+res.layouts[i]))
+	}
+
+	// The same queries against both layouts
+	type query struct {
+		addr  common.Address
+		slots []common.Hash
+	}
+	qs := make([]query, queries)
+	for i := range qs {
+		qs[i].addr = addrs[r.Intn(len(addrs))]
+		for j := 0; j < 8; j++ {
+			qs[i].slots = append(qs[i].slots, common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("slot-%d", r.Intn(nSlots))))))
+		}
+	}
+	for _, db := range dbs {
+		accounts, err := trie.NewStateTrie(trie.StateTrieID(env.root), db)
+		if err != nil {
+			return nil, err
+		}
+		var latencies []int
+		for _, q := range qs {
+			acc, err := accounts.GetAccount(q.addr)
+			if err != nil || acc == nil {
+				return nil, fmt.Errorf("account %x: %v", q.addr, err)
+			}
+			start := time.Now()
+			storage, err := trie.NewStateTrie(trie.StorageTrieID(env.root, crypto.Keccak256Hash(q.addr[:]), acc.Root), db)
+			if err != nil {
+				return nil, err
+			}
+			for _, slot := range q.slots {
+				if _, err := storage.GetStorage(q.addr, slot[:]); err != nil {
+					return nil, err
+				}
+			}
+			latencies = append(latencies, int(time.Since(start).Nanoseconds()))
+		}
+		res.reads = append(res.reads, latencies)
+	}
+	return res, nil
+}
+
+func (res *clusterResult) report() {
+	fmt.Printf("\n--- Storage Clustering ---\n")
+	for i, layout := range res.layouts {
+		fmt.Printf("%-10s %d nodes, %.2f MB, 8-slot read: %s ns\n", layout+":", res.nodes[i], float64(res.sizes[i])/(1024*1024), distribution(res.reads[i]))
+	}
+}
+
+// freezeResult holds the measurements of the freezing experiment.
+type freezeResult struct {
+	moved, movedBytes     int
+	liveBefore, liveAfter int64
+	elapsed               time.Duration
+	current, historical   []int // slot read latency in ns
+}
+
+// freezeHistoricalNodes moves all trie nodes that are only reachable from
+// roots older than the current one out of the live database into a cold
+// store, compacts the live database to realize the shrinkage, and compares
+// slot reads at the historical root oldRoot, which now partly hit the cold
+// store, with the same reads at the current root.
+func freezeHistoricalNodes(env *benchEnv, db ethdb.Database, dir string, oldRoot common.Hash, addrs []common.Address, nSlots int, r *rand.Rand) (*freezeResult, error) {
+	cold, err := newColdStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		res   = &freezeResult{liveBefore: getDirSize(filepath.Dir(dir)) - cold.size}
+		live  = make(map[common.Hash]struct{})
+		start = time.Now()
+		nodes = &tieredNodeDB{live: db, cold: cold}
+	)
+	err = walkStateNodes(env.trieDB, env.root, func(_, hash common.Hash, _ []byte) bool {
+		if _, ok := live[hash]; ok {
+			return false
+		}
+		live[hash] = struct{}{}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	var (
+		batch = db.NewBatch()
+		werr  error
+	)
+	for _, root := range env.history[:len(env.history)-1] {
+		err := walkStateNodes(nodes, root, func(_, hash common.Hash, blob []byte) bool {
+			if werr != nil {
+				return false
+			}
+			if _, ok := live[hash]; ok {
+				return false // shared with the live state, stays hot
+			}
+			if _, ok := cold.index[hash]; ok {
+				return false
+			}
+			if werr = cold.append(hash, blob); werr != nil {
+				return false
+			}
+			rawdb.DeleteLegacyTrieNode(batch, hash)
+			res.moved++
+			res.movedBytes += len(blob)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if werr != nil {
+			return nil, werr
+		}
+		if batch.ValueSize() > ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return nil, err
+			}
+			batch.Reset()
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return nil, err
+	}
+	if err := db.Compact(nil, nil); err != nil {
+		return nil, err
+	}
+	res.elapsed = time.Since(start)
+	res.liveAfter = getDirSize(filepath.Dir(dir)) - cold.size
+
+	// Historical reads against the old root versus the current root
+	for _, root := range []common.Hash{env.root, oldRoot} {
+		latencies := &res.current
+		if root != env.root {
+			latencies = &res.historical
+		}
+		accounts, err := trie.NewStateTrie(trie.StateTrieID(root), nodes)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < 1000; i++ {
+			addr := addrs[r.Intn(len(addrs))]
+			slot := common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("slot-%d", r.Intn(nSlots)))))
+
+			start := time.Now()
+			acc, err := accounts.GetAccount(addr)
+			if err != nil || acc == nil {
+				return nil, fmt.Errorf("account %x at root %x: %v", addr, root, err)
+			}
+			storage, err := trie.NewStateTrie(trie.StorageTrieID(root, crypto.Keccak256Hash(addr[:]), acc.Root), nodes)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := storage.GetStorage(addr, slot[:]); err != nil {
+				return nil, err
+			}
+			*latencies = append(*latencies, int(time.Since(start).Nanoseconds()))
+		}
+	}
+	return res, nil
+}
+
+func (res *freezeResult) report() {
+	fmt.Printf("\n--- Cold Storage ---\n")
+	fmt.Printf("Frozen Nodes:  %d (%.2f MB) in %v\n", res.moved, float64(res.movedBytes)/(1024*1024), res.elapsed)
+	fmt.Printf("Live DB:       %.2f MB -> %.2f MB\n", float64(res.liveBefore)/(1024*1024), float64(res.liveAfter)/(1024*1024))
+	fmt.Printf("Read (head):   %s ns\n", distribution(res.current))
+	fmt.Printf("Read (old):    %s ns\n", distribution(res.historical))
+}
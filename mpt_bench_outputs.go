@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/triedb"
+	"github.com/golang/snappy"
+)
+
+// slotValue derives the value of a slot from label. Keccak output is the
+// worst case for compression, while real contract storage is dominated by
+// small counters and balances and by packed, repetitive layouts, which the
+// small and pattern entropies imitate. The hash is computed either way, so
+// that generation costs the same.
+func slotValue(entropy, label string) common.Hash {
+	h := crypto.Keccak256Hash([]byte(label))
+	var v common.Hash
+	switch entropy {
+	case "small":
+		binary.BigEndian.PutUint64(v[24:], binary.BigEndian.Uint64(h[:8])%10000+1)
+	case "pattern":
+		pattern := []byte{0xde, 0xad, 0xbe, h[0] % 8}
+		for i := range v {
+			v[i] = pattern[i%len(pattern)]
+		}
+	default:
+		v = h
+	}
+	return v
+}
+
+// reportCompressibility compares the raw size of the distinct trie nodes of
+// the final state with their snappy-compressed size, the compression LevelDB
+// applies to its blocks, and with the disk usage of the database.
+func reportCompressibility(env *benchEnv, entropy string, disk int64) error {
+	var (
+		raw, compressed int
+		seen            = make(map[common.Hash]struct{})
+	)
+	err := walkStateNodes(env.trieDB, env.root, func(_, hash common.Hash, blob []byte) bool {
+		if _, ok := seen[hash]; ok {
+			return false
+		}
+		seen[hash] = struct{}{}
+		raw += len(blob)
+		compressed += len(snappy.Encode(nil, blob))
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\n--- Value Compressibility (%s) ---\n", entropy)
+	fmt.Printf("Trie Nodes:    %d, %.2f MB raw, %.2f MB snappy (%.1f%%)\n", len(seen),
+		float64(raw)/(1024*1024), float64(compressed)/(1024*1024), 100*float64(compressed)/float64(max(raw, 1)))
+	fmt.Printf("Disk Usage:    %.2f MB (%.2fx the raw node bytes)\n", float64(disk)/(1024*1024), float64(disk)/float64(max(raw, 1)))
+	return nil
+}
+
+// touchCounter counts how often every account and every slot is touched, the
+// access frequencies cache replacement policies are evaluated against. All
+// methods are no-ops on a nil receiver.
+type touchCounter struct {
+	accounts map[common.Address]int
+	slots    map[[common.AddressLength + common.HashLength]byte]int
+}
+
+func newTouchCounter() *touchCounter {
+	return &touchCounter{
+		accounts: make(map[common.Address]int),
+		slots:    make(map[[common.AddressLength + common.HashLength]byte]int),
+	}
+}
+
+func (tc *touchCounter) account(addr common.Address) {
+	if tc != nil {
+		tc.accounts[addr]++
+	}
+}
+
+func (tc *touchCounter) slot(addr common.Address, slot common.Hash) {
+	if tc == nil {
+		return
+	}
+	var key [common.AddressLength + common.HashLength]byte
+	copy(key[:], addr[:])
+	copy(key[common.AddressLength:], slot[:])
+	tc.slots[key]++
+}
+
+// write exports the counts as CSV, most touched first, with an empty slot
+// column for account rows.
+func (tc *touchCounter) write(path string) error {
+	type row struct {
+		account common.Address
+		slot    string
+		touches int
+	}
+	rows := make([]row, 0, len(tc.accounts)+len(tc.slots))
+	for addr, n := range tc.accounts {
+		rows = append(rows, row{addr, "", n})
+	}
+	for key, n := range tc.slots {
+		rows = append(rows, row{common.BytesToAddress(key[:common.AddressLength]), common.BytesToHash(key[common.AddressLength:]).Hex(), n})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].touches > rows[j].touches })
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "account,slot,touches")
+	for _, r := range rows {
+		fmt.Fprintf(w, "%s,%s,%d\n", r.account.Hex(), r.slot, r.touches)
+	}
+	return w.Flush()
+}
+
+// traceSample is a single operation recorded in the latency trace. Account
+// and slot are indices into the generated ones, -1 where not applicable.
+type traceSample struct {
+	seq           int // position in the operation stream
+	op            string
+	account, slot int
+	batch         uint64
+	latency       time.Duration
+}
+
+// latencyTrace keeps a uniform random sample of a fixed number of operations
+// out of all of them (reservoir sampling), so that tail latencies can be
+// examined offline without storing every single operation. All methods are
+// no-ops on a nil receiver.
+type latencyTrace struct {
+	size    int
+	seen    int
+	samples []traceSample
+	rand    *rand.Rand
+}
+
+func newLatencyTrace(size int, seed int64) *latencyTrace {
+	return &latencyTrace{size: size, rand: rand.New(rand.NewSource(seed))}
+}
+
+func (lt *latencyTrace) start() time.Time {
+	if lt == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+func (lt *latencyTrace) record(op string, account, slot int, batch uint64, start time.Time) {
+	if lt == nil {
+		return
+	}
+	sample := traceSample{seq: lt.seen, op: op, account: account, slot: slot, batch: batch, latency: time.Since(start)}
+	lt.seen++
+	if len(lt.samples) < lt.size {
+		lt.samples = append(lt.samples, sample)
+	} else if i := lt.rand.Intn(lt.seen); i < lt.size {
+		lt.samples[i] = sample
+	}
+}
+
+// write stores the sample as CSV, in the order the operations happened.
+func (lt *latencyTrace) write(path string) error {
+	sort.Slice(lt.samples, func(i, j int) bool {
+		return lt.samples[i].seq < lt.samples[j].seq
+	})
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "seq,op,account,slot,batch,latency_ns")
+	for _, s := range lt.samples {
+		fmt.Fprintf(w, "%d,%s,%d,%d,%d,%d\n", s.seq, s.op, s.account, s.slot, s.batch, s.latency.Nanoseconds())
+	}
+	return w.Flush()
+}
+
+// stateDiffWriter exports the changes of every commit as a JSON state diff in
+// the format of trace_replayBlockTransactions: per account, each of balance,
+// nonce, code and storage slot is "=" if unchanged, {"+": new} if created,
+// {"-": old} if deleted and {"*": {"from": old, "to": new}} if modified. The
+// diffs are derived by comparing the tries of the parent and the new root,
+// which covers every workload; hashed keys are mapped back to the accounts
+// and slots the benchmark generates, anything else is reported by its hash.
+type stateDiffWriter struct {
+	dir      string
+	trieDB   *triedb.Database
+	accounts map[common.Hash]common.Address
+	slots    map[common.Hash]common.Hash
+}
+
+func newStateDiffWriter(dir string, trieDB *triedb.Database, nAccounts, nSlots int) *stateDiffWriter {
+	sd := &stateDiffWriter{
+		dir:      dir,
+		trieDB:   trieDB,
+		accounts: make(map[common.Hash]common.Address),
+		slots:    make(map[common.Hash]common.Hash),
+	}
+	for _, addr := range []common.Address{deployer, create2Factory} {
+		sd.accounts[crypto.Keccak256Hash(addr[:])] = addr
+	}
+	for i := 0; i < nAccounts; i++ {
+		addr := common.BytesToAddress(crypto.Keccak256([]byte(fmt.Sprintf("account-%d", i)))[:20])
+		sd.accounts[crypto.Keccak256Hash(addr[:])] = addr
+	}
+	for j := 0; j < nSlots; j++ {
+		slot := common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("slot-%d", j))))
+		sd.slots[crypto.Keccak256Hash(slot[:])] = slot
+	}
+	return sd
+}
+
+// diffLeaves returns the leaves that differ between the tries at the roots
+// parent and root, as old and new value, nil where a leaf is absent.
+func (sd *stateDiffWriter) diffLeaves(parent, root *trie.Trie) (map[common.Hash][2][]byte, error) {
+	changes := make(map[common.Hash][2][]byte)
+	for i, pair := range [][2]*trie.Trie{{parent, root}, {root, parent}} {
+		it, _ := trie.NewDifferenceIterator(pair[0].MustNodeIterator(nil), pair[1].MustNodeIterator(nil))
+		for it.Next(true) {
+			if !it.Leaf() {
+				continue
+			}
+			key := common.BytesToHash(it.LeafKey())
+			if _, ok := changes[key]; ok {
+				continue
+			}
+			other, err := pair[0].Get(key[:])
+			if err != nil {
+				return nil, err
+			}
+			change := [2][]byte{other, common.CopyBytes(it.LeafBlob())}
+			if i == 1 {
+				change[0], change[1] = change[1], change[0]
+			}
+			if !bytes.Equal(change[0], change[1]) {
+				changes[key] = change
+			}
+		}
+		if it.Error() != nil {
+			return nil, it.Error()
+		}
+	}
+	return changes, nil
+}
+
+// diffField renders the change of one field in the state diff format.
+func diffField(from, to any, existed, exists bool) any {
+	switch {
+	case !existed:
+		return map[string]any{"+": to}
+	case !exists:
+		return map[string]any{"-": from}
+	case fmt.Sprint(from) == fmt.Sprint(to):
+		return "="
+	}
+	return map[string]any{"*": map[string]any{"from": from, "to": to}}
+}
+
+// write diffs the state at root against its parent and stores the result as
+// <dir>/block-<block>.json.
+func (sd *stateDiffWriter) write(block uint64, parent, root common.Hash) error {
+	if parent == (common.Hash{}) {
+		parent = types.EmptyRootHash
+	}
+	open := func(id *trie.ID) (*trie.Trie, error) { return trie.New(id, sd.trieDB) }
+	parentTrie, err := open(trie.StateTrieID(parent))
+	if err != nil {
+		return err
+	}
+	rootTrie, err := open(trie.StateTrieID(root))
+	if err != nil {
+		return err
+	}
+	changes, err := sd.diffLeaves(parentTrie, rootTrie)
+	if err != nil {
+		return err
+	}
+	diff := make(map[string]any)
+	for key, change := range changes {
+		var accs [2]*types.StateAccount
+		for k, blob := range change {
+			if blob == nil {
+				continue
+			}
+			accs[k] = new(types.StateAccount)
+			if err := rlp.DecodeBytes(blob, accs[k]); err != nil {
+				return err
+			}
+		}
+		// Missing accounts compare as empty ones
+		var (
+			old, cur = accs[0], accs[1]
+			existed  = old != nil
+			exists   = cur != nil
+		)
+		if old == nil {
+			old = types.NewEmptyStateAccount()
+		}
+		if cur == nil {
+			cur = types.NewEmptyStateAccount()
+		}
+		code := func(hash []byte) hexutil.Bytes {
+			return rawdb.ReadCode(sd.trieDB.Disk(), common.BytesToHash(hash))
+		}
+		entry := map[string]any{
+			"balance": diffField((*hexutil.Big)(old.Balance.ToBig()), (*hexutil.Big)(cur.Balance.ToBig()), existed, exists),
+			"nonce":   diffField(hexutil.Uint64(old.Nonce), hexutil.Uint64(cur.Nonce), existed, exists),
+			"code":    diffField(code(old.CodeHash), code(cur.CodeHash), existed, exists),
+		}
+		storage := make(map[string]any)
+		if old.Root != cur.Root {
+			parentStorage, err := open(trie.StorageTrieID(parent, key, old.Root))
+			if err != nil {
+				return err
+			}
+			rootStorage, err := open(trie.StorageTrieID(root, key, cur.Root))
+			if err != nil {
+				return err
+			}
+			slots, err := sd.diffLeaves(parentStorage, rootStorage)
+			if err != nil {
+				return err
+			}
+			for slotHash, values := range slots {
+				var vals [2]common.Hash
+				for k, blob := range values {
+					if blob != nil {
+						_, content, _, err := rlp.Split(blob)
+						if err != nil {
+							return err
+						}
+						vals[k] = common.BytesToHash(content)
+					}
+				}
+				name := slotHash.Hex()
+				if slot, ok := sd.slots[slotHash]; ok {
+					name = slot.Hex()
+				}
+				storage[name] = diffField(vals[0], vals[1], values[0] != nil, values[1] != nil)
+			}
+		}
+		entry["storage"] = storage
+
+		name := key.Hex()
+		if addr, ok := sd.accounts[key]; ok {
+			name = addr.Hex()
+		}
+		diff[name] = entry
+	}
+	blob, err := json.MarshalIndent(map[string]any{
+		"block":     block,
+		"stateRoot": root,
+		"stateDiff": diff,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(sd.dir, fmt.Sprintf("block-%d.json", block)), blob, 0644)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestStateDiffs checks that every statedb lifetime and scheme diffs each
+// flush against the previous one: the creation diffs add all accounts and the
+// modification diffs touch exactly the modified ones.
+func TestStateDiffs(t *testing.T) {
+	for _, extra := range [][]string{
+		{"-statedb", "recreate"},
+		{"-statedb", "reuse"},
+		{"-statedb", "reuse", "-scheme", "path"},
+	} {
+		dir := t.TempDir()
+		args := append([]string{"-n", "30", "-slots", "6", "-m", "12", "-k", "5", "-seed", "9", "-state-diffs", dir, "-db", filepath.Join(t.TempDir(), "db")}, extra...)
+		runBench(t, args...)
+
+		files, _ := filepath.Glob(filepath.Join(dir, "block-*.json"))
+		if len(files) == 0 {
+			t.Fatalf("%v: no state diffs written", extra)
+		}
+		created, modified := make(map[string]bool), make(map[string]bool)
+		for _, file := range files {
+			blob, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var diff struct {
+				StateDiff map[string]map[string]any `json:"stateDiff"`
+			}
+			if err := json.Unmarshal(blob, &diff); err != nil {
+				t.Fatalf("%v: %s: %v", extra, file, err)
+			}
+			if len(diff.StateDiff) == 0 {
+				t.Errorf("%v: %s holds no changed accounts", extra, filepath.Base(file))
+			}
+			for addr, fields := range diff.StateDiff {
+				balance, _ := fields["balance"].(map[string]any)
+				if _, ok := balance["+"]; ok {
+					created[addr] = true
+				} else {
+					modified[addr] = true
+				}
+			}
+		}
+		if len(created) != 30 || len(modified) != 12 {
+			t.Errorf("%v: %d accounts created and %d modified, want 30 and 12", extra, len(created), len(modified))
+		}
+	}
+}
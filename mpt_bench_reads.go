@@ -0,0 +1,929 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"os"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb/leveldb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/trie/trienode"
+	"github.com/ethereum/go-ethereum/triedb/database"
+	"github.com/holiman/uint256"
+)
+
+// accountUpdateResult holds the measurements of the account update phase.
+type accountUpdateResult struct {
+	updates int
+	batches int
+	elapsed time.Duration
+	commits []int // µs per batch commit, summed over all shards
+}
+
+// runAccountUpdates bumps the balance and nonce of random existing accounts,
+// committing every batchSize accounts as the next block of the chain.
+// No storage is written, so the cost is that of the account trie alone and
+// can be set against the modification phase, which pays for both.
+func runAccountUpdates(shards []*benchEnv, addrs []common.Address, updates, batchSize int, r *rand.Rand) (*accountUpdateResult, error) {
+	res := &accountUpdateResult{updates: updates}
+	start := time.Now()
+	for i := 0; i < updates; i++ {
+		idx := r.Intn(len(addrs))
+		statedb := shards[idx%len(shards)].statedb
+		statedb.AddBalance(addrs[idx], uint256.NewInt(1), tracing.BalanceChangeUnspecified)
+		statedb.SetNonce(addrs[idx], statedb.GetNonce(addrs[idx])+1, tracing.NonceChangeUnspecified)
+
+		if (i+1)%batchSize != 0 && i+1 != updates {
+			continue
+		}
+		commitStart := time.Now()
+		for s, shard := range shards {
+			if err := shard.commit(shard.chain.number); err != nil {
+				return nil, fmt.Errorf("shard %d: %v", s, err)
+			}
+		}
+		shards[0].chain.seal()
+		res.commits = append(res.commits, int(time.Since(commitStart).Microseconds()))
+		res.batches++
+	}
+	if err := flushShards(shards, true); err != nil {
+		return nil, err
+	}
+	res.elapsed = time.Since(start)
+	return res, nil
+}
+
+// report sets the time per account against that of the modification phase,
+// whose accounts also had 500 slots written each.
+func (res *accountUpdateResult) report(modifyTime time.Duration, modified int) {
+	fmt.Printf("\n--- Account Updates ---\n")
+	fmt.Printf("Updates:       %d in %d batches, %v\n", res.updates, res.batches, res.elapsed)
+	fmt.Printf("Batch Commit:  %s µs\n", distribution(res.commits))
+	perAccount := float64(res.elapsed.Microseconds()) / float64(res.updates)
+	fmt.Printf("Per Account:   %.1f µs balance and nonce only", perAccount)
+	if modified > 0 {
+		fmt.Printf(", %.1f µs with 500 slots in the modification phase", float64(modifyTime.Microseconds())/float64(modified))
+	}
+	fmt.Println()
+}
+
+// snapshotReadResult holds the measurements of the snapshot read phase.
+type snapshotReadResult struct {
+	idle, busy []int // read latency in ns without and with a concurrent writer
+	views      []int // ns to open a read view at the pinned root
+	mismatches int
+	commits    int // commits made by the writer while the reads were served
+}
+
+// runSnapshotReads serves slot reads from state views pinned at the current
+// root while a writer goroutine keeps modifying and committing state on top of
+// it, the way an RPC node keeps answering queries during block import.
+//
+// go-ethereum no longer exposes storage-engine snapshots through ethdb, so the
+// view is pinned by its state root instead: in hash scheme the nodes of a
+// committed root are never overwritten, which yields the same consistency
+// guarantee. Every read is checked against the value captured before the
+// writer started, and latencies are compared to the same reads with the
+// writer idle. A fresh view is opened per 100 reads, as per RPC request.
+func runSnapshotReads(env *benchEnv, addrs []common.Address, nSlots, reads int, r *rand.Rand) (*snapshotReadResult, error) {
+	type query struct {
+		addr common.Address
+		slot common.Hash
+		want common.Hash
+	}
+	var (
+		root    = env.root
+		queries = make([]query, reads)
+		res     = new(snapshotReadResult)
+	)
+	for i := range queries {
+		queries[i].addr = addrs[r.Intn(len(addrs))]
+		queries[i].slot = common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("slot-%d", r.Intn(nSlots)))))
+		queries[i].want = env.statedb.GetState(queries[i].addr, queries[i].slot)
+	}
+	serve := func(latencies *[]int) error {
+		var view *state.StateDB
+		for i, q := range queries {
+			if i%100 == 0 {
+				start := time.Now()
+				var err error
+				if view, err = state.New(root, env.sdb); err != nil {
+					return err
+				}
+				res.views = append(res.views, int(time.Since(start).Nanoseconds()))
+			}
+			start := time.Now()
+			got := view.GetState(q.addr, q.slot)
+			*latencies = append(*latencies, int(time.Since(start).Nanoseconds()))
+			if got != q.want {
+				res.mismatches++
+			}
+		}
+		return nil
+	}
+	if err := serve(&res.idle); err != nil {
+		return nil, err
+	}
+	var (
+		stop    = make(chan struct{})
+		done    = make(chan error, 1)
+		wrand   = rand.New(rand.NewSource(r.Int63()))
+		written int
+	)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				done <- nil
+				return
+			default:
+			}
+			addr := addrs[wrand.Intn(len(addrs))]
+			for j := 0; j < 100; j++ {
+				slot := common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("slot-%d", wrand.Intn(nSlots)))))
+				env.statedb.SetState(addr, slot, common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("snap-%d-%d", written, j)))))
+			}
+			if written++; written%10 == 0 {
+				if err := env.endBlock(); err != nil {
+					done <- err
+					return
+				}
+				res.commits++
+			}
+		}
+	}()
+	err := serve(&res.busy)
+	close(stop)
+	if werr := <-done; werr != nil {
+		return nil, werr
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Persist whatever the writer left pending so the final root is consistent
+	if err := env.endBlock(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (res *snapshotReadResult) report() {
+	fmt.Printf("\n--- Snapshot Reads ---\n")
+	fmt.Printf("View Open:     %s ns\n", distribution(res.views))
+	fmt.Printf("Read (idle):   %s ns\n", distribution(res.idle))
+	fmt.Printf("Read (busy):   %s ns (writer committed %d times)\n", distribution(res.busy), res.commits)
+	fmt.Printf("Consistency:   %d mismatches\n", res.mismatches)
+}
+
+// snapshotLayerResult holds the read latencies over growing stacks of
+// snapshot diff layers.
+type snapshotLayerResult struct {
+	generate time.Duration
+	depths   []int
+	deep     [][]int // ns per read of a slot only the bottom layer wrote
+	disk     [][]int // ns per read of a slot no layer wrote
+	flatten  time.Duration
+}
+
+// runSnapshotLayers generates the snapshot of the final state and stacks
+// diff layers on it one block at a time, each writing 10 slots in each of 100
+// random accounts, without flattening. At every power of two depth it times
+// reads from the top layer of slots only the bottom layer wrote, which walk
+// the whole stack, and of slots no layer wrote, which the aggregated bloom
+// filter sends straight to the disk layer. Finally the stack is flattened
+// into the disk layer in one go.
+//
+// The layers are keyed by synthetic roots: only the snapshot is exercised,
+// the tries are not updated to match. The snapshot is therefore kept in a
+// LevelDB of its own next to dbPath and deleted afterwards, leaving the
+// benchmark database and its disk usage untouched.
+func runSnapshotLayers(env *benchEnv, dbPath string, addrs []common.Address, nSlots, layers int, r *rand.Rand) (*snapshotLayerResult, error) {
+	path := dbPath + "-snapshot"
+	os.RemoveAll(path)
+	db, err := leveldb.New(path, 256, 256, "eth/db/snapshot/", false)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(path)
+	defer db.Close()
+
+	res := new(snapshotLayerResult)
+	start := time.Now()
+	snaps, err := snapshot.New(snapshot.Config{CacheSize: 256}, db, env.trieDB, env.root)
+	if err != nil {
+		return nil, err
+	}
+	defer snaps.Release()
+	res.generate = time.Since(start)
+
+	value := func(label string) []byte {
+		val := crypto.Keccak256Hash([]byte(label))
+		blob, _ := rlp.EncodeToBytes(common.TrimLeftZeroes(val[:]))
+		return blob
+	}
+	var (
+		parent = env.root
+		deep   [][2]common.Hash // account and slot hashes only the bottom layer wrote
+		next   = 1
+	)
+	for layer := 1; layer <= layers; layer++ {
+		root := crypto.Keccak256Hash([]byte(fmt.Sprintf("snapshot-layer-%d", layer)))
+		storage := make(map[common.Hash]map[common.Hash][]byte)
+		for a := 0; a < 100; a++ {
+			accHash := crypto.Keccak256Hash(addrs[r.Intn(len(addrs))][:])
+			if storage[accHash] == nil {
+				storage[accHash] = make(map[common.Hash][]byte)
+			}
+			for j := 0; j < 10; j++ {
+				slot := crypto.Keccak256Hash([]byte(fmt.Sprintf("slot-%d", r.Intn(nSlots))))
+				storage[accHash][crypto.Keccak256Hash(slot[:])] = value(fmt.Sprintf("layer-%d-%d-%d", layer, a, j))
+			}
+			if layer == 1 {
+				slotHash := crypto.Keccak256Hash([]byte(fmt.Sprintf("deep-slot-%d", a)))
+				storage[accHash][slotHash] = value(fmt.Sprintf("deep-%d", a))
+				deep = append(deep, [2]common.Hash{accHash, slotHash})
+			}
+		}
+		if err := snaps.Update(root, parent, make(map[common.Hash][]byte), storage); err != nil {
+			return nil, err
+		}
+		parent = root
+		if layer != next && layer != layers {
+			continue
+		}
+		next *= 2
+
+		top := snaps.Snapshot(root)
+		var deepReads, diskReads []int
+		for i := 0; i < 1000; i++ {
+			key := deep[r.Intn(len(deep))]
+			start := time.Now()
+			if _, err := top.Storage(key[0], key[1]); err != nil {
+				return nil, err
+			}
+			deepReads = append(deepReads, int(time.Since(start).Nanoseconds()))
+
+			accHash := crypto.Keccak256Hash(addrs[r.Intn(len(addrs))][:])
+			slot := crypto.Keccak256Hash([]byte(fmt.Sprintf("slot-%d", r.Intn(nSlots))))
+			start = time.Now()
+			if _, err := top.Storage(accHash, crypto.Keccak256Hash(slot[:])); err != nil {
+				return nil, err
+			}
+			diskReads = append(diskReads, int(time.Since(start).Nanoseconds()))
+		}
+		res.depths = append(res.depths, layer)
+		res.deep = append(res.deep, deepReads)
+		res.disk = append(res.disk, diskReads)
+	}
+	start = time.Now()
+	if err := snaps.Cap(parent, 0); err != nil {
+		return nil, err
+	}
+	res.flatten = time.Since(start)
+	return res, nil
+}
+
+func (res *snapshotLayerResult) report() {
+	fmt.Printf("\n--- Snapshot Diff Layers ---\n")
+	fmt.Printf("Generation:    %v\n", res.generate)
+	fmt.Printf("%8s %14s %14s %14s %14s\n", "Layers", "Deep p50 (ns)", "Deep p99 (ns)", "Disk p50 (ns)", "Disk p99 (ns)")
+	for i, depth := range res.depths {
+		deep := append([]int(nil), res.deep[i]...)
+		disk := append([]int(nil), res.disk[i]...)
+		sort.Ints(deep)
+		sort.Ints(disk)
+		fmt.Printf("%8d %14d %14d %14d %14d\n", depth, deep[len(deep)/2], deep[len(deep)*99/100], disk[len(disk)/2], disk[len(disk)*99/100])
+	}
+	fmt.Printf("Flatten:       %d layers into the disk layer in %v\n", res.depths[len(res.depths)-1], res.flatten)
+}
+
+// archiveReadResult holds the latencies of the historical read phase, bucketed
+// by the age of the queried root in commits: bucket b covers the ages in
+// [2^(b-1), 2^b), bucket 0 the head itself.
+type archiveReadResult struct {
+	buckets     [][]int // ns per query, opening the state included
+	unavailable []int   // queries whose root could not be opened
+}
+
+// runArchiveReads queries slot 0 of addr at randomly picked roots from the
+// commit history, opening a fresh state at the root for every query the way
+// eth_call at an old block does. In hash scheme every committed root stays
+// resolvable; in path scheme only the most recent layers do, and queries
+// beyond them are counted as unavailable.
+func runArchiveReads(env *benchEnv, addr common.Address, queries int, r *rand.Rand) (*archiveReadResult, error) {
+	if len(env.history) == 0 {
+		return nil, fmt.Errorf("no committed roots to query")
+	}
+	var (
+		slot = common.BytesToHash(crypto.Keccak256([]byte("slot-0")))
+		res  = new(archiveReadResult)
+	)
+	for i := 0; i < queries; i++ {
+		var (
+			idx    = r.Intn(len(env.history))
+			bucket = bits.Len(uint(len(env.history) - 1 - idx))
+		)
+		for len(res.buckets) <= bucket {
+			res.buckets = append(res.buckets, nil)
+			res.unavailable = append(res.unavailable, 0)
+		}
+		start := time.Now()
+		statedb, err := state.New(env.history[idx], env.sdb)
+		if err != nil {
+			res.unavailable[bucket]++
+			continue
+		}
+		statedb.GetState(addr, slot)
+		res.buckets[bucket] = append(res.buckets[bucket], int(time.Since(start).Nanoseconds()))
+	}
+	return res, nil
+}
+
+func (res *archiveReadResult) report() {
+	fmt.Printf("\n--- Historical Reads ---\n")
+	fmt.Printf("%-12s %8s %6s %s\n", "Age (blocks)", "Queries", "N/A", "Latency (ns)")
+	for b, latencies := range res.buckets {
+		age := "0"
+		if b > 0 {
+			age = fmt.Sprintf("%d-%d", 1<<(b-1), 1<<b-1)
+		}
+		fmt.Printf("%-12s %8d %6d %s\n", age, len(latencies), res.unavailable[b], distribution(latencies))
+	}
+}
+
+// multiproofRow compares proving a batch of keys one by one with proving them
+// all at once into a single deduplicated node set.
+type multiproofRow struct {
+	accounts, slots            int
+	singleBytes, combinedBytes int
+	singleTime, combinedTime   time.Duration
+}
+
+// runMultiproofs proves batches of 1, 4, 16, ... up to maxAccounts random
+// accounts together with 16 slots of each, once as independent per-key proofs
+// and once as a single multiproof, the union of all proof nodes. Shared upper
+// trie levels are what a multiproof saves, and the saving grows with the
+// batch, which is the trade-off witness batching designs are after.
+func runMultiproofs(env *benchEnv, addrs []common.Address, nSlots, maxAccounts int, r *rand.Rand) ([]multiproofRow, error) {
+	accTrie, err := trie.New(trie.StateTrieID(env.root), env.trieDB)
+	if err != nil {
+		return nil, err
+	}
+	var rows []multiproofRow
+	for batch := 1; batch <= min(maxAccounts, len(addrs)); batch *= 4 {
+		type target struct {
+			tr   *trie.Trie
+			keys [][]byte
+		}
+		var (
+			row     = multiproofRow{accounts: batch}
+			targets = []*target{{tr: accTrie}}
+		)
+		for _, idx := range r.Perm(len(addrs))[:batch] {
+			addr := addrs[idx]
+			addrHash := crypto.Keccak256Hash(addr[:])
+			targets[0].keys = append(targets[0].keys, addrHash[:])
+
+			st, err := trie.New(trie.StorageTrieID(env.root, addrHash, env.statedb.GetStorageRoot(addr)), env.trieDB)
+			if err != nil {
+				return nil, err
+			}
+			t := &target{tr: st}
+			for _, j := range r.Perm(nSlots)[:min(16, nSlots)] {
+				t.keys = append(t.keys, crypto.Keccak256(crypto.Keccak256([]byte(fmt.Sprintf("slot-%d", j)))))
+			}
+			row.slots += len(t.keys)
+			targets = append(targets, t)
+		}
+		start := time.Now()
+		for _, t := range targets {
+			for _, key := range t.keys {
+				var proof trienode.ProofList
+				if err := t.tr.Prove(key, &proof); err != nil {
+					return nil, err
+				}
+				for _, node := range proof {
+					row.singleBytes += len(node)
+				}
+			}
+		}
+		row.singleTime = time.Since(start)
+
+		start = time.Now()
+		combined := trienode.NewProofSet()
+		for _, t := range targets {
+			for _, key := range t.keys {
+				if err := t.tr.Prove(key, combined); err != nil {
+					return nil, err
+				}
+			}
+		}
+		row.combinedTime = time.Since(start)
+		row.combinedBytes = combined.DataSize()
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func reportMultiproofs(rows []multiproofRow) {
+	fmt.Printf("\n--- Multiproofs ---\n")
+	fmt.Printf("%8s %8s %12s %12s %8s %12s %12s\n", "Accounts", "Slots", "Single (KB)", "Multi (KB)", "Saved", "Single (µs)", "Multi (µs)")
+	for _, row := range rows {
+		fmt.Printf("%8d %8d %12.1f %12.1f %7.1f%% %12d %12d\n", row.accounts, row.slots,
+			float64(row.singleBytes)/1024, float64(row.combinedBytes)/1024, 100*(1-float64(row.combinedBytes)/float64(row.singleBytes)),
+			row.singleTime.Microseconds(), row.combinedTime.Microseconds())
+	}
+}
+
+// lightQuery is what a light client receives for a single slot: the proof of
+// the account against the state root, and of the slot against the account's
+// storage root.
+type lightQuery struct {
+	accKey, slotKey     []byte
+	accProof, slotProof trienode.ProofList
+}
+
+// lightVerifyResult holds the throughput of the light client phase.
+type lightVerifyResult struct {
+	queries    int
+	proofBytes int
+	rows       [][2]float64 // workers, verified queries per second
+}
+
+// runLightVerification generates account and slot proofs for random slots of
+// the final state, then verifies them the way a light client does, knowing
+// nothing but the state root: every proof is indexed by node hash and walked
+// from the root, the account decoded, and the slot proof walked from its
+// storage root. Verification runs on one core and on all of them to show how
+// it scales with light client hardware.
+func runLightVerification(env *benchEnv, addrs []common.Address, nSlots, queries int, r *rand.Rand) (*lightVerifyResult, error) {
+	accTrie, err := trie.New(trie.StateTrieID(env.root), env.trieDB)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		res   = &lightVerifyResult{queries: queries}
+		batch = make([]lightQuery, queries)
+	)
+	for i := range batch {
+		addr := addrs[r.Intn(len(addrs))]
+		addrHash := crypto.Keccak256Hash(addr[:])
+		st, err := trie.New(trie.StorageTrieID(env.root, addrHash, env.statedb.GetStorageRoot(addr)), env.trieDB)
+		if err != nil {
+			return nil, err
+		}
+		q := &batch[i]
+		q.accKey = addrHash[:]
+		q.slotKey = crypto.Keccak256(crypto.Keccak256([]byte(fmt.Sprintf("slot-%d", r.Intn(nSlots)))))
+		if err := accTrie.Prove(q.accKey, &q.accProof); err != nil {
+			return nil, err
+		}
+		if err := st.Prove(q.slotKey, &q.slotProof); err != nil {
+			return nil, err
+		}
+		for _, node := range append(q.accProof, q.slotProof...) {
+			res.proofBytes += len(node)
+		}
+	}
+	verify := func(q *lightQuery) error {
+		blob, err := trie.VerifyProof(env.root, q.accKey, q.accProof.Set())
+		if err != nil {
+			return err
+		}
+		if blob == nil {
+			return fmt.Errorf("account %x missing", q.accKey)
+		}
+		var acc types.StateAccount
+		if err := rlp.DecodeBytes(blob, &acc); err != nil {
+			return err
+		}
+		_, err = trie.VerifyProof(acc.Root, q.slotKey, q.slotProof.Set())
+		return err
+	}
+	for _, workers := range []int{1, runtime.GOMAXPROCS(0)} {
+		var (
+			wg    sync.WaitGroup
+			errs  = make(chan error, workers)
+			start = time.Now()
+		)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := w; i < len(batch); i += workers {
+					if err := verify(&batch[i]); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+		close(errs)
+		if err := <-errs; err != nil {
+			return nil, err
+		}
+		res.rows = append(res.rows, [2]float64{float64(workers), float64(queries) / time.Since(start).Seconds()})
+		if workers == 1 && runtime.GOMAXPROCS(0) == 1 {
+			break
+		}
+	}
+	return res, nil
+}
+
+func (res *lightVerifyResult) report() {
+	fmt.Printf("\n--- Light Client Verification (%d queries, %.1f KB of proofs each) ---\n",
+		res.queries, float64(res.proofBytes)/float64(res.queries)/1024)
+	fmt.Printf("%8s %14s %14s\n", "Cores", "Queries/s", "Per Core")
+	for _, row := range res.rows {
+		fmt.Printf("%8.0f %14.0f %14.0f\n", row[0], row[1], row[1]/row[0])
+	}
+}
+
+// rpcCalls are the state-reading RPC calls the query mix blends.
+var rpcCalls = []string{"getBalance", "getStorageAt", "getProof", "getCode"}
+
+// parseQueryWeights parses comma-separated call=weight pairs into weights in
+// the order of rpcCalls; calls left out get no share.
+func parseQueryWeights(s string) ([]int, error) {
+	weights := make([]int, len(rpcCalls))
+	total := 0
+	for _, pair := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(pair, "=")
+		idx := slices.Index(rpcCalls, name)
+		if !ok || idx < 0 {
+			return nil, fmt.Errorf("%q is not a call=weight pair of %s", pair, strings.Join(rpcCalls, ", "))
+		}
+		var weight int
+		if _, err := fmt.Sscanf(value, "%d", &weight); err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight %q of %s", value, name)
+		}
+		weights[idx] = weight
+		total += weight
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("all weights are zero")
+	}
+	return weights, nil
+}
+
+// queryMixResult holds the outcome of the query mix.
+type queryMixResult struct {
+	workers   int
+	elapsed   time.Duration
+	latencies [][]int // µs per call, by rpcCalls index
+}
+
+// runQueryMix serves the given number of RPC calls, drawn by weight, from
+// concurrent clients against the final root. Every call opens its own state
+// view at the root, as a node serving the call at the head block does, and
+// getProof proves the account and one of its slots like eth_getProof with a
+// single storage key.
+func runQueryMix(env *benchEnv, addrs []common.Address, nSlots, queries, workers int, weights []int, r *rand.Rand) (*queryMixResult, error) {
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	call := func(kind int, addr common.Address, slot common.Hash) error {
+		statedb, err := state.New(env.root, env.sdb)
+		if err != nil {
+			return err
+		}
+		switch rpcCalls[kind] {
+		case "getBalance":
+			statedb.GetBalance(addr)
+		case "getStorageAt":
+			statedb.GetState(addr, slot)
+		case "getCode":
+			statedb.GetCode(addr)
+		case "getProof":
+			accTrie, err := trie.New(trie.StateTrieID(env.root), env.trieDB)
+			if err != nil {
+				return err
+			}
+			var accProof, slotProof trienode.ProofList
+			if err := accTrie.Prove(crypto.Keccak256(addr[:]), &accProof); err != nil {
+				return err
+			}
+			st, err := trie.New(trie.StorageTrieID(env.root, crypto.Keccak256Hash(addr[:]), statedb.GetStorageRoot(addr)), env.trieDB)
+			if err != nil {
+				return err
+			}
+			return st.Prove(crypto.Keccak256(slot[:]), &slotProof)
+		}
+		return statedb.Error()
+	}
+	var (
+		res   = &queryMixResult{workers: workers, latencies: make([][]int, len(rpcCalls))}
+		lats  = make([][][]int, workers)
+		seeds = make([]int64, workers)
+		wg    sync.WaitGroup
+		errs  = make(chan error, workers)
+	)
+	for w := range seeds {
+		seeds[w] = r.Int63()
+	}
+	start := time.Now()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			wr := rand.New(rand.NewSource(seeds[w]))
+			lats[w] = make([][]int, len(rpcCalls))
+			for i := w; i < queries; i += workers {
+				kind, pick := 0, wr.Intn(total)
+				for pick >= weights[kind] {
+					pick -= weights[kind]
+					kind++
+				}
+				addr := addrs[wr.Intn(len(addrs))]
+				slot := common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("slot-%d", wr.Intn(nSlots)))))
+
+				callStart := time.Now()
+				if err := call(kind, addr, slot); err != nil {
+					errs <- fmt.Errorf("%s: %v", rpcCalls[kind], err)
+					return
+				}
+				lats[w][kind] = append(lats[w][kind], int(time.Since(callStart).Microseconds()))
+			}
+		}(w)
+	}
+	wg.Wait()
+	res.elapsed = time.Since(start)
+	close(errs)
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+	for _, worker := range lats {
+		for kind, samples := range worker {
+			res.latencies[kind] = append(res.latencies[kind], samples...)
+		}
+	}
+	return res, nil
+}
+
+func (res *queryMixResult) report() {
+	calls := 0
+	for _, samples := range res.latencies {
+		calls += len(samples)
+	}
+	fmt.Printf("\n--- RPC Query Mix ---\n")
+	fmt.Printf("RPC QPS:       %.0f (%d calls from %d clients in %v)\n", float64(calls)/res.elapsed.Seconds(), calls, res.workers, res.elapsed.Round(time.Millisecond))
+	for kind, name := range rpcCalls {
+		if len(res.latencies[kind]) == 0 {
+			continue
+		}
+		fmt.Printf("%-14s %7d calls, %s µs\n", name+":", len(res.latencies[kind]), distribution(res.latencies[kind]))
+	}
+}
+
+// tracingNodeDB times every node resolution of the tries opened on top of it,
+// by trie and depth, and tells the nodes the trie database served from memory
+// (its caches, dirty nodes or diff layers) from those that had to be read from
+// the key-value store, as the read counter underneath shows.
+type tracingNodeDB struct {
+	db      database.NodeDatabase
+	counter *countingStore
+	levels  [2][]traceLevel // account and storage trie, by depth in nibbles
+	lookups []int           // µs per traced lookup
+}
+
+type traceLevel struct {
+	nodes, disk int
+	elapsed     time.Duration
+}
+
+func (db *tracingNodeDB) NodeReader(root common.Hash) (database.NodeReader, error) {
+	reader, err := db.db.NodeReader(root)
+	if err != nil {
+		return nil, err
+	}
+	return &tracingNodeReader{NodeReader: reader, db: db}, nil
+}
+
+type tracingNodeReader struct {
+	database.NodeReader
+	db *tracingNodeDB
+}
+
+func (r *tracingNodeReader) Node(owner common.Hash, path []byte, hash common.Hash) ([]byte, error) {
+	reads := r.db.counter.reads.Load()
+	start := time.Now()
+	blob, err := r.NodeReader.Node(owner, path, hash)
+	elapsed := time.Since(start)
+
+	kind := 0
+	if owner != (common.Hash{}) {
+		kind = 1
+	}
+	for len(r.db.levels[kind]) <= len(path) {
+		r.db.levels[kind] = append(r.db.levels[kind], traceLevel{})
+	}
+	level := &r.db.levels[kind][len(path)]
+	level.nodes++
+	level.elapsed += elapsed
+	if r.db.counter.reads.Load() > reads {
+		level.disk++
+	}
+	return blob, err
+}
+
+// traceReadPaths looks up random accounts and one of their slots each through
+// freshly opened tries, so that every node on both paths is resolved anew and
+// passes through the tracing reader.
+func traceReadPaths(env *benchEnv, counter *countingStore, addrs []common.Address, nSlots, lookups int, r *rand.Rand) (*tracingNodeDB, error) {
+	db := &tracingNodeDB{db: env.trieDB, counter: counter}
+	for i := 0; i < lookups; i++ {
+		addr := addrs[r.Intn(len(addrs))]
+		slot := common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("slot-%d", r.Intn(nSlots)))))
+
+		start := time.Now()
+		accounts, err := trie.NewStateTrie(trie.StateTrieID(env.root), db)
+		if err != nil {
+			return nil, err
+		}
+		acc, err := accounts.GetAccount(addr)
+		if err != nil || acc == nil {
+			return nil, fmt.Errorf("account %x: %v", addr, err)
+		}
+		if acc.Root != types.EmptyRootHash {
+			storage, err := trie.NewStateTrie(trie.StorageTrieID(env.root, crypto.Keccak256Hash(addr[:]), acc.Root), db)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := storage.GetStorage(addr, slot[:]); err != nil {
+				return nil, err
+			}
+		}
+		db.lookups = append(db.lookups, int(time.Since(start).Microseconds()))
+	}
+	return db, nil
+}
+
+func (db *tracingNodeDB) report() {
+	fmt.Printf("\n--- Read Path by Trie Level ---\n")
+	fmt.Printf("Lookup:        %s µs\n", distribution(db.lookups))
+	fmt.Printf("%-8s %6s %10s %10s %10s %8s\n", "Trie", "Depth", "Nodes", "Avg (µs)", "Per Lookup", "Disk")
+	for kind, name := range []string{"account", "storage"} {
+		for depth, level := range db.levels[kind] {
+			if level.nodes == 0 {
+				continue // no node ends at this depth, the parent was an extension
+			}
+			fmt.Printf("%-8s %6d %10d %10.1f %10.1f %7.1f%%\n", name, depth, level.nodes,
+				float64(level.elapsed.Nanoseconds())/1e3/float64(level.nodes),
+				float64(level.elapsed.Nanoseconds())/1e3/float64(len(db.lookups)),
+				float64(level.disk)/float64(level.nodes)*100)
+		}
+	}
+}
+
+// existenceFilter is a bloom filter over the accounts and slots in the state,
+// with 10 bits and 7 probes per entry for a false positive rate below 1%.
+type existenceFilter struct {
+	bits []uint64
+}
+
+func newExistenceFilter(entries int) *existenceFilter {
+	return &existenceFilter{bits: make([]uint64, max(1, (10*entries+63)/64))}
+}
+
+// probes derives the bit positions of key by double hashing.
+func (f *existenceFilter) probes(key []byte, visit func(bit uint64) bool) bool {
+	var (
+		h    = crypto.Keccak256(key)
+		h1   = binary.BigEndian.Uint64(h[:8])
+		h2   = binary.BigEndian.Uint64(h[8:16])
+		size = uint64(len(f.bits) * 64)
+	)
+	for i := uint64(0); i < 7; i++ {
+		if !visit((h1 + i*h2) % size) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *existenceFilter) add(key []byte) {
+	f.probes(key, func(bit uint64) bool {
+		f.bits[bit/64] |= 1 << (bit % 64)
+		return true
+	})
+}
+
+func (f *existenceFilter) has(key []byte) bool {
+	return f.probes(key, func(bit uint64) bool {
+		return f.bits[bit/64]&(1<<(bit%64)) != 0
+	})
+}
+
+// negativeReadResult holds the measurements of the negative lookup phase.
+type negativeReadResult struct {
+	plain, filtered           []int // lookup latency in ns
+	plainReads, filteredReads int64 // key-value store reads
+	plainBytes, filteredBytes int64
+	falsePositives            int
+	filterBytes               int
+	buildTime                 time.Duration
+}
+
+// runNegativeReads looks up keys that do not exist, half of them accounts that
+// were never created and half of them slots beyond the ones every account
+// holds, the way transactions probe for unused storage or fresh recipients.
+// The lookups are served once through an existence filter over all accounts
+// and slots of Phase 1, which answers most of them without touching the trie,
+// and then straight from the trie. Filtered lookups run first, so that caches
+// warmed by the plain lookups cannot flatter them.
+func runNegativeReads(env *benchEnv, counter *countingStore, addrs []common.Address, nSlots, reads int) (*negativeReadResult, error) {
+	type query struct {
+		addr common.Address
+		slot common.Hash
+	}
+	var (
+		res     = new(negativeReadResult)
+		queries = make([]query, reads)
+		filter  = newExistenceFilter(len(addrs) * (1 + nSlots))
+		key     = func(addr common.Address, slot common.Hash) []byte {
+			return append(addr.Bytes(), slot.Bytes()...)
+		}
+	)
+	start := time.Now()
+	for _, addr := range addrs {
+		filter.add(addr.Bytes())
+		for j := 0; j < nSlots; j++ {
+			filter.add(key(addr, common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("slot-%d", j))))))
+		}
+	}
+	res.buildTime, res.filterBytes = time.Since(start), len(filter.bits)*8
+
+	for i := range queries {
+		if i%2 == 0 {
+			queries[i].addr = common.BytesToAddress(crypto.Keccak256([]byte(fmt.Sprintf("missing-account-%d", i)))[:20])
+		} else {
+			queries[i].addr = addrs[i%len(addrs)]
+		}
+		queries[i].slot = common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("slot-%d", nSlots+i))))
+	}
+	serve := func(useFilter bool, latencies *[]int) error {
+		var view *state.StateDB
+		for i, q := range queries {
+			if i%100 == 0 {
+				var err error
+				if view, err = state.New(env.root, env.sdb); err != nil {
+					return err
+				}
+			}
+			start := time.Now()
+			if useFilter {
+				probe := q.addr.Bytes()
+				if i%2 == 1 {
+					probe = key(q.addr, q.slot)
+				}
+				if !filter.has(probe) {
+					*latencies = append(*latencies, int(time.Since(start).Nanoseconds()))
+					continue
+				}
+				res.falsePositives++
+			}
+			view.GetState(q.addr, q.slot)
+			*latencies = append(*latencies, int(time.Since(start).Nanoseconds()))
+		}
+		return nil
+	}
+	reads0, bytes0 := counter.reads.Load(), counter.bytes.Load()
+	if err := serve(true, &res.filtered); err != nil {
+		return nil, err
+	}
+	reads1, bytes1 := counter.reads.Load(), counter.bytes.Load()
+	if err := serve(false, &res.plain); err != nil {
+		return nil, err
+	}
+	res.filteredReads, res.filteredBytes = reads1-reads0, bytes1-bytes0
+	res.plainReads, res.plainBytes = counter.reads.Load()-reads1, counter.bytes.Load()-bytes1
+	return res, nil
+}
+
+func (res *negativeReadResult) report() {
+	fmt.Printf("\n--- Negative Lookups ---\n")
+	fmt.Printf("Filter:        %.2f MB, built in %v, %d false positives (%.2f%%)\n", float64(res.filterBytes)/(1024*1024),
+		res.buildTime.Round(time.Millisecond), res.falsePositives, 100*float64(res.falsePositives)/float64(max(len(res.filtered), 1)))
+	fmt.Printf("Trie:          %s ns, %d db reads (%.2f MB)\n", distribution(res.plain), res.plainReads, float64(res.plainBytes)/(1024*1024))
+	fmt.Printf("Filtered:      %s ns, %d db reads (%.2f MB)\n", distribution(res.filtered), res.filteredReads, float64(res.filteredBytes)/(1024*1024))
+	if res.plainReads > 0 {
+		fmt.Printf("Eliminated:    %.1f%% of db reads\n", 100*(1-float64(res.filteredReads)/float64(res.plainReads)))
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestExistenceFilter(t *testing.T) {
+	for _, entries := range []int{0, 1, 100, 10000} {
+		f := newExistenceFilter(entries)
+		for i := 0; i < entries; i++ {
+			f.add([]byte(fmt.Sprintf("present-%d", i)))
+		}
+		for i := 0; i < entries; i++ {
+			if key := fmt.Sprintf("present-%d", i); !f.has([]byte(key)) {
+				t.Fatalf("%d entries: false negative for %s", entries, key)
+			}
+		}
+		if entries == 0 {
+			if f.has([]byte("absent")) {
+				t.Errorf("empty filter claims to hold a key")
+			}
+			continue
+		}
+		// 10 bits and 7 probes per entry give about 0.8% false positives
+		var positives int
+		for i := 0; i < 100000; i++ {
+			if f.has([]byte(fmt.Sprintf("absent-%d", i))) {
+				positives++
+			}
+		}
+		if rate := float64(positives) / 100000; entries >= 100 && rate > 0.015 {
+			t.Errorf("%d entries: false positive rate %.4f above 1.5%%", entries, rate)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon2"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/trie/trienode"
+	"github.com/ethereum/go-ethereum/triedb"
+	"github.com/holiman/uint256"
+	"golang.org/x/crypto/blake2b"
+)
+
+// trieShape summarises the nodes produced by committing a trie.
+type trieShape struct {
+	nodes    int
+	bytes    int
+	depthSum int // sum of node depths in nibbles
+	maxDepth int
+	elapsed  time.Duration // insertion and hashing time
+}
+
+func (ts *trieShape) add(set *trienode.NodeSet) {
+	if set == nil {
+		return
+	}
+	for path, n := range set.Nodes {
+		ts.nodes++
+		ts.bytes += len(n.Blob)
+		ts.depthSum += len(path)
+		ts.maxDepth = max(ts.maxDepth, len(path))
+	}
+}
+
+// buildShapeTrie inserts the given keys into a fresh in-memory trie, hashing
+// them first if requested, commits it and accumulates the shape into ts.
+func buildShapeTrie(keys, values [][]byte, hashKeys bool, ts *trieShape) common.Hash {
+	start := time.Now()
+	tr := trie.NewEmpty(triedb.NewDatabase(rawdb.NewMemoryDatabase(), nil))
+	for i, key := range keys {
+		if hashKeys {
+			key = crypto.Keccak256(key)
+		}
+		tr.MustUpdate(key, values[i])
+	}
+	root, set := tr.Commit(false)
+	ts.elapsed += time.Since(start)
+	ts.add(set)
+	return root
+}
+
+// compareKeyHashing rebuilds the Phase 1 state twice with the trie package
+// directly: once as the secure trie does, keying accounts by keccak(address)
+// and slots by keccak(slot index), and once with the raw address and the raw
+// 32-byte slot index as keys. Real contracts use small sequential slot indices,
+// which the hashing spreads out, so this quantifies what key hashing costs in
+// time and what it buys in trie balance.
+func compareKeyHashing(addrs []common.Address, nSlots int) {
+	var (
+		slotKeys = make([][]byte, nSlots)
+		slotVals = make([][]byte, nSlots)
+	)
+	for j := 0; j < nSlots; j++ {
+		slotKeys[j] = common.BigToHash(big.NewInt(int64(j))).Bytes()
+		val := common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("value-%d", j))))
+		slotVals[j], _ = rlp.EncodeToBytes(common.TrimLeftZeroes(val[:]))
+	}
+	fmt.Printf("\n--- Key Hashing ---\n")
+	fmt.Printf("%-12s %8s %10s %12s %10s %8s\n", "Keys", "Time", "Nodes", "Size (MB)", "AvgDepth", "MaxDepth")
+	for _, hashed := range []bool{true, false} {
+		var (
+			storage, accounts trieShape
+			accKeys           = make([][]byte, len(addrs))
+			accVals           = make([][]byte, len(addrs))
+		)
+		// All accounts share the same slot layout, so one storage trie is
+		// built and its shape scaled by the number of accounts.
+		storageRoot := buildShapeTrie(slotKeys, slotVals, hashed, &storage)
+		for i, addr := range addrs {
+			accKeys[i] = addr.Bytes()
+			accVals[i], _ = rlp.EncodeToBytes(&types.StateAccount{
+				Nonce:    uint64(i),
+				Balance:  uint256.NewInt(1e18),
+				Root:     storageRoot,
+				CodeHash: types.EmptyCodeHash.Bytes(),
+			})
+		}
+		buildShapeTrie(accKeys, accVals, hashed, &accounts)
+
+		total := trieShape{
+			nodes:    accounts.nodes + storage.nodes*len(addrs),
+			bytes:    accounts.bytes + storage.bytes*len(addrs),
+			depthSum: accounts.depthSum + storage.depthSum*len(addrs),
+			maxDepth: max(accounts.maxDepth, storage.maxDepth),
+			elapsed:  accounts.elapsed + storage.elapsed*time.Duration(len(addrs)),
+		}
+		name := "raw"
+		if hashed {
+			name = "keccak"
+		}
+		for _, row := range []struct {
+			label string
+			shape trieShape
+		}{{name + "/acc", accounts}, {name + "/slot", storage}, {name, total}} {
+			fmt.Printf("%-12s %8v %10d %12.2f %10.2f %8d\n", row.label, row.shape.elapsed.Round(time.Microsecond),
+				row.shape.nodes, float64(row.shape.bytes)/(1024*1024),
+				float64(row.shape.depthSum)/float64(max(row.shape.nodes, 1)), row.shape.maxDepth)
+		}
+	}
+}
+
+// researchHashes are the hash functions the trie nodes can be rehashed with
+// for research purposes, along with their digest size. None of them is
+// consensus-compatible, the resulting roots are meaningless to Ethereum.
+var researchHashes = map[string]struct {
+	size int
+	sum  func([]byte) []byte
+}{
+	"keccak256": {32, func(b []byte) []byte { return crypto.Keccak256(b) }},
+	"sha256":    {32, func(b []byte) []byte { h := sha256.Sum256(b); return h[:] }},
+	"blake2b":   {32, func(b []byte) []byte { h := blake2b.Sum256(b); return h[:] }},
+	"keccak160": {20, func(b []byte) []byte { return crypto.Keccak256(b)[12:] }},
+	"poseidon2": {32, poseidon2Sum},
+}
+
+// poseidon2Perm is the Poseidon2 permutation over the BN254 scalar field with
+// width 3, the round numbers gnark-crypto benchmarks it with and round
+// constants derived from a fixed seed.
+var poseidon2Perm = poseidon2.NewHash(3, 8, 56, "mpt-bench")
+
+// poseidon2Sum hashes a blob with a sponge over poseidon2Perm: two 31-byte
+// chunks, which always fit a field element, are absorbed per permutation and
+// the first element is squeezed out, with the blob length in the capacity
+// element. This is a research construction, not a standardized hash mode, but
+// it costs what a field-friendly trie hasher would.
+func poseidon2Sum(blob []byte) []byte {
+	var state [3]fr.Element
+	state[2].SetUint64(uint64(len(blob)))
+	for i := 0; i == 0 || i < len(blob); i += 62 {
+		for j := 0; j < 2; j++ {
+			var (
+				chunk = blob[min(i+31*j, len(blob)):min(i+31*(j+1), len(blob))]
+				elem  fr.Element
+			)
+			elem.SetBytes(chunk)
+			state[j].Add(&state[j], &elem)
+		}
+		poseidon2Perm.Permutation(state[:])
+	}
+	digest := state[0].Bytes()
+	return digest[:]
+}
+
+// hashReferences counts the 32-byte child hashes referenced by an encoded trie
+// node, which is what a hash function with a different digest size resizes.
+func hashReferences(blob []byte) int {
+	elems, _, err := rlp.SplitList(blob)
+	if err != nil {
+		return 0
+	}
+	var items [][]byte
+	for len(elems) > 0 {
+		item, rest, err := rlp.SplitString(elems)
+		if err != nil { // embedded child node
+			_, _, rest, err = rlp.Split(elems)
+			if err != nil {
+				return 0
+			}
+			item = nil
+		}
+		items, elems = append(items, item), rest
+	}
+	// Leaves (short nodes with the terminator flag) carry values, not hashes
+	if len(items) == 2 && len(items[0]) > 0 && items[0][0]>>4 >= 2 {
+		return 0
+	}
+	// The 17th item of a full node is its value, not a child
+	if len(items) == 17 {
+		items = items[:16]
+	}
+	var refs int
+	for _, item := range items {
+		if len(item) == common.HashLength {
+			refs++
+		}
+	}
+	return refs
+}
+
+// compareHashFunctions rehashes every distinct trie node of the final state
+// with each of the given hash functions and compares time and estimated trie
+// size to keccak256. go-ethereum's hasher is not pluggable, so this is an
+// estimate rather than a trie built with another hasher: the node encodings
+// are taken as they are and only the digests are computed anew, hashing cost
+// scales with the node bytes, and the size is derived arithmetically from the
+// difference in digest length for every child reference.
+func compareHashFunctions(env *benchEnv, names []string) error {
+	var (
+		blobs [][]byte
+		seen  = make(map[common.Hash]struct{})
+	)
+	err := walkStateNodes(env.trieDB, env.root, func(_, hash common.Hash, blob []byte) bool {
+		if _, ok := seen[hash]; ok {
+			return false
+		}
+		seen[hash] = struct{}{}
+		blobs = append(blobs, common.CopyBytes(blob))
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	var size, refs int
+	for _, blob := range blobs {
+		size += len(blob)
+		refs += hashReferences(blob)
+	}
+	measure := func(sum func([]byte) []byte) time.Duration {
+		start := time.Now()
+		for _, blob := range blobs {
+			sum(blob)
+		}
+		return time.Since(start)
+	}
+	base := measure(researchHashes["keccak256"].sum)
+
+	fmt.Printf("\n--- Hash Function Rehash Estimate (%d nodes, %.2f MB, %d child references) ---\n", len(blobs), float64(size)/(1024*1024), refs)
+	fmt.Printf("%-10s %12s %10s %10s %14s\n", "Function", "Time", "MB/s", "vs keccak", "Est. Size (MB)")
+	for _, name := range append([]string{"keccak256"}, names...) {
+		var (
+			fn      = researchHashes[name]
+			elapsed = base
+		)
+		if name != "keccak256" {
+			elapsed = measure(fn.sum)
+		}
+		resized := size - refs*(common.HashLength-fn.size)
+		fmt.Printf("%-10s %12v %10.1f %9.2fx %14.2f\n", name, elapsed.Round(time.Microsecond),
+			float64(size)/(1024*1024)/elapsed.Seconds(), float64(elapsed)/float64(base), float64(resized)/(1024*1024))
+	}
+	return nil
+}
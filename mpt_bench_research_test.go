@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+func TestHashReferences(t *testing.T) {
+	var (
+		hash     = crypto.Keccak256([]byte("child"))
+		value    = common.LeftPadBytes([]byte{1}, common.HashLength)
+		embedded = rlp.RawValue(mustEncode(t, []any{[]byte{0x35}, []byte{0x01}}))
+	)
+	full := func(children ...any) []any {
+		node := make([]any, 17)
+		for i := range node {
+			node[i] = []byte{}
+		}
+		copy(node, children)
+		return node
+	}
+	tests := []struct {
+		name string
+		node []byte
+		want int
+	}{
+		{"full node", mustEncode(t, full(hash, []byte{}, hash, hash)), 3},
+		{"full node with embedded child", mustEncode(t, full(hash, embedded, hash)), 2},
+		{"full node with value", mustEncode(t, append(full(hash)[:16], value)), 1},
+		{"even extension", mustEncode(t, []any{[]byte{0x00, 0xab}, hash}), 1},
+		{"odd extension", mustEncode(t, []any{[]byte{0x1a}, hash}), 1},
+		{"extension to embedded node", mustEncode(t, []any{[]byte{0x00, 0xab}, embedded}), 0},
+		{"even leaf with 32-byte value", mustEncode(t, []any{[]byte{0x20, 0xab}, value}), 0},
+		{"odd leaf with 32-byte value", mustEncode(t, []any{[]byte{0x3a}, value}), 0},
+		{"not a list", mustEncode(t, hash), 0},
+		{"garbage", []byte{0xff, 0x01}, 0},
+	}
+	for _, tt := range tests {
+		if have := hashReferences(tt.node); have != tt.want {
+			t.Errorf("%s: have %d references, want %d", tt.name, have, tt.want)
+		}
+	}
+}
+
+// TestResearchHashes checks that every research hash function returns digests
+// of its declared size that tell apart blobs around the chunk boundaries of
+// the poseidon2 sponge, including blobs differing only in trailing zeros.
+func TestResearchHashes(t *testing.T) {
+	var blobs [][]byte
+	for _, n := range []int{0, 1, 30, 31, 32, 61, 62, 63, 124, 532} {
+		blob := make([]byte, n)
+		for i := range blob {
+			blob[i] = byte(i*7 + 1)
+		}
+		blobs = append(blobs, blob, append(blob, 0))
+	}
+	for name, fn := range researchHashes {
+		seen := make(map[string]int)
+		for i, blob := range blobs {
+			digest := fn.sum(blob)
+			if len(digest) != fn.size {
+				t.Errorf("%s: digest of %d bytes, want %d", name, len(digest), fn.size)
+			}
+			if !bytes.Equal(digest, fn.sum(blob)) {
+				t.Errorf("%s: digest of blob %d not deterministic", name, i)
+			}
+			if j, ok := seen[string(digest)]; ok {
+				t.Errorf("%s: blobs %d and %d collide", name, j, i)
+			}
+			seen[string(digest)] = i
+		}
+	}
+}
+
+func mustEncode(t *testing.T, v any) []byte {
+	t.Helper()
+	blob, err := rlp.EncodeToBytes(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blob
+}
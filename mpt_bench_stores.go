@@ -0,0 +1,572 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/bits"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/leveldb"
+)
+
+// countingStore counts the reads that reach the key-value store, which is the
+// disk traffic the trie database's caches did not absorb.
+type countingStore struct {
+	ethdb.KeyValueStore
+	reads, bytes atomic.Int64
+}
+
+func (s *countingStore) Get(key []byte) ([]byte, error) {
+	blob, err := s.KeyValueStore.Get(key)
+	s.reads.Add(1)
+	s.bytes.Add(int64(len(blob)))
+	return blob, err
+}
+
+// churnStore accounts every write reaching the key-value store at key level:
+// bytes put under a new key are added, bytes put over an existing key are
+// rewritten and the bytes of a deleted key are logically deleted, none of
+// which the directory size tells apart once compaction kicks in. The sizes of
+// all keys seen are kept in memory, others are looked up in the store once.
+type churnStore struct {
+	ethdb.KeyValueStore
+	lock   sync.Mutex
+	sizes  map[string]int // key plus value bytes, -1 once deleted
+	cur    churnCounts
+	phases []churnPhase
+}
+
+type churnCounts struct {
+	added, rewritten, deleted int64
+	puts, deletes             int
+}
+
+// churnPhase is the churn of one phase along with the accounts and slots the
+// phase modified.
+type churnPhase struct {
+	name            string
+	accounts, slots int
+	churnCounts
+}
+
+func newChurnStore(db ethdb.KeyValueStore) *churnStore {
+	return &churnStore{KeyValueStore: db, sizes: make(map[string]int)}
+}
+
+// size returns the stored key plus value bytes of key, or -1 if it is absent.
+// The caller holds the lock.
+func (s *churnStore) size(key []byte) int {
+	if size, ok := s.sizes[string(key)]; ok {
+		return size
+	}
+	blob, err := s.KeyValueStore.Get(key)
+	if err != nil {
+		return -1
+	}
+	return len(key) + len(blob)
+}
+
+func (s *churnStore) account(key []byte, size int, del bool) {
+	old := s.size(key)
+	switch {
+	case del:
+		if old >= 0 {
+			s.cur.deleted += int64(old)
+		}
+		s.cur.deletes++
+		size = -1
+	case old >= 0:
+		s.cur.rewritten += int64(size)
+		s.cur.puts++
+	default:
+		s.cur.added += int64(size)
+		s.cur.puts++
+	}
+	s.sizes[string(key)] = size
+}
+
+func (s *churnStore) Put(key []byte, value []byte) error {
+	s.lock.Lock()
+	s.account(key, len(key)+len(value), false)
+	s.lock.Unlock()
+	return s.KeyValueStore.Put(key, value)
+}
+
+func (s *churnStore) Delete(key []byte) error {
+	s.lock.Lock()
+	s.account(key, 0, true)
+	s.lock.Unlock()
+	return s.KeyValueStore.Delete(key)
+}
+
+func (s *churnStore) NewBatch() ethdb.Batch {
+	return &churnBatch{Batch: s.KeyValueStore.NewBatch(), store: s}
+}
+
+func (s *churnStore) NewBatchWithSize(size int) ethdb.Batch {
+	return &churnBatch{Batch: s.KeyValueStore.NewBatchWithSize(size), store: s}
+}
+
+// phase closes the current phase, attributing its churn to the given number
+// of modified accounts and slots.
+func (s *churnStore) phase(name string, accounts, slots int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.phases = append(s.phases, churnPhase{name: name, accounts: accounts, slots: slots, churnCounts: s.cur})
+	s.cur = churnCounts{}
+}
+
+// report prints the churn per phase, and the bytes written (added plus
+// rewritten) per modified account and slot.
+func (s *churnStore) report() {
+	perUnit := func(n int64, units int) float64 {
+		if units == 0 {
+			return 0
+		}
+		return float64(n) / float64(units)
+	}
+	fmt.Printf("\n--- State Churn ---\n")
+	fmt.Printf("%-14s %12s %14s %12s %12s %14s %12s\n", "Phase", "Added (MB)", "Rewritten (MB)", "Deleted (MB)", "Net (MB)", "B/Account", "B/Slot")
+	for _, p := range s.phases {
+		written := p.added + p.rewritten
+		fmt.Printf("%-14s %12.2f %14.2f %12.2f %12.2f %14.0f %12.1f\n", p.name,
+			float64(p.added)/(1024*1024), float64(p.rewritten)/(1024*1024), float64(p.deleted)/(1024*1024),
+			float64(p.added-p.deleted)/(1024*1024), perUnit(written, p.accounts), perUnit(written, p.slots))
+	}
+}
+
+// churnBatch defers the accounting of its writes until the batch is written.
+type churnBatch struct {
+	ethdb.Batch
+	store *churnStore
+	ops   []churnOp
+}
+
+type churnOp struct {
+	key  string
+	size int
+	del  bool
+}
+
+func (b *churnBatch) Put(key []byte, value []byte) error {
+	b.ops = append(b.ops, churnOp{key: string(key), size: len(key) + len(value)})
+	return b.Batch.Put(key, value)
+}
+
+func (b *churnBatch) Delete(key []byte) error {
+	b.ops = append(b.ops, churnOp{key: string(key), del: true})
+	return b.Batch.Delete(key)
+}
+
+func (b *churnBatch) Write() error {
+	b.store.lock.Lock()
+	for _, op := range b.ops {
+		b.store.account([]byte(op.key), op.size, op.del)
+	}
+	b.store.lock.Unlock()
+	b.ops = b.ops[:0]
+	return b.Batch.Write()
+}
+
+func (b *churnBatch) Reset() {
+	b.ops = b.ops[:0]
+	b.Batch.Reset()
+}
+
+// accessRecorder records the sequence of trie node reads reaching the
+// key-value store in the hash scheme, where nodes are the only 32-byte keys.
+// The hash database keeps no clean cache by default, so once a batch is
+// committed every node the tries resolve shows up here: exactly the stream a
+// clean cache would have to serve.
+type accessRecorder struct {
+	ethdb.KeyValueStore
+	lock   sync.Mutex
+	ids    map[common.Hash]uint32
+	sizes  []int // node size by id
+	cur    *accessPhase
+	phases []*accessPhase
+}
+
+// accessPhase is the node read sequence of one phase, as node ids.
+type accessPhase struct {
+	name string
+	seq  []uint32
+}
+
+func newAccessRecorder(db ethdb.KeyValueStore) *accessRecorder {
+	return &accessRecorder{KeyValueStore: db, ids: make(map[common.Hash]uint32)}
+}
+
+// begin ends the phase being recorded, if any, and starts recording the next.
+func (ar *accessRecorder) begin(name string) {
+	if ar == nil {
+		return
+	}
+	ar.lock.Lock()
+	defer ar.lock.Unlock()
+	ar.cur = &accessPhase{name: name}
+	ar.phases = append(ar.phases, ar.cur)
+}
+
+func (ar *accessRecorder) Get(key []byte) ([]byte, error) {
+	blob, err := ar.KeyValueStore.Get(key)
+	if err != nil || len(key) != common.HashLength {
+		return blob, err
+	}
+	ar.lock.Lock()
+	defer ar.lock.Unlock()
+	if ar.cur == nil {
+		return blob, err
+	}
+	hash := common.BytesToHash(key)
+	id, ok := ar.ids[hash]
+	if !ok {
+		id = uint32(len(ar.sizes))
+		ar.ids[hash] = id
+		ar.sizes = append(ar.sizes, len(blob))
+	}
+	ar.cur.seq = append(ar.cur.seq, id)
+	return blob, err
+}
+
+// reuseDistances calls visit with the LRU stack distance of every access in
+// seq: the number of distinct other nodes read since the previous read of the
+// same node, or -1 for its first read. A Fenwick tree marks the last access
+// of every node, so the distinct nodes in between are a range count.
+func reuseDistances(seq []uint32, visit func(dist int)) {
+	var (
+		tree = make([]int, len(seq)+1)
+		last = make(map[uint32]int)
+	)
+	add := func(i, v int) {
+		for i++; i < len(tree); i += i & -i {
+			tree[i] += v
+		}
+	}
+	prefix := func(i int) (sum int) { // marks in [0, i)
+		for ; i > 0; i -= i & -i {
+			sum += tree[i]
+		}
+		return sum
+	}
+	for t, id := range seq {
+		if p, ok := last[id]; ok {
+			visit(prefix(t) - prefix(p+1))
+			add(p, -1)
+		} else {
+			visit(-1)
+		}
+		add(t, 1)
+		last[id] = t
+	}
+}
+
+// report prints per phase the hit ratio of an LRU clean cache of growing
+// size, as a node count and as the bytes of that many average-sized nodes of
+// the phase. A cache of C nodes serves every read with a reuse distance
+// below C; first reads always miss.
+func (ar *accessRecorder) report() {
+	fmt.Printf("\n--- Trie Node Access Locality ---\n")
+	for _, p := range ar.phases {
+		if len(p.seq) == 0 {
+			continue
+		}
+		var (
+			buckets  = make([]int, 1) // reads by bits.Len of their reuse distance
+			distinct int
+			size     int64
+			seen     = make(map[uint32]bool)
+		)
+		reuseDistances(p.seq, func(dist int) {
+			if dist < 0 {
+				distinct++
+				return
+			}
+			b := bits.Len(uint(dist))
+			for len(buckets) <= b {
+				buckets = append(buckets, 0)
+			}
+			buckets[b]++
+		})
+		for _, id := range p.seq {
+			if !seen[id] {
+				seen[id] = true
+				size += int64(ar.sizes[id])
+			}
+		}
+		avg := float64(size) / float64(distinct)
+		fmt.Printf("%s: %d reads of %d distinct nodes (%.2f MB working set, %.0f B per node)\n",
+			p.name, len(p.seq), distinct, float64(size)/(1024*1024), avg)
+		fmt.Printf("%14s %12s %10s\n", "Cache (nodes)", "Cache (MB)", "Hit Ratio")
+		hits := 0
+		for b := 0; ; b++ {
+			if b < len(buckets) {
+				hits += buckets[b]
+			}
+			capacity := 1 << b
+			if b >= 4 && b%2 == 0 || capacity >= distinct {
+				fmt.Printf("%14d %12.2f %9.1f%%\n", capacity, float64(capacity)*avg/(1024*1024), float64(hits)/float64(len(p.seq))*100)
+			}
+			if capacity >= distinct {
+				break
+			}
+		}
+	}
+}
+
+// routedTable is one of the LevelDB instances behind a prefixRouter.
+type routedTable struct {
+	name    string
+	match   func(key []byte) bool
+	db      ethdb.KeyValueStore
+	growth  *growthCurve
+	written int64 // key and value bytes put into the table
+}
+
+// prefixRouter physically separates data categories by dispatching every key
+// to a dedicated LevelDB instance based on its rawdb schema prefix. Keys that
+// match no category end up in the embedded meta table, which also serves all
+// operations without a key to route by.
+//
+// Account and storage trie nodes can only be told apart in the path scheme; in
+// the hash scheme they share the "trie" table of 32-byte hash keys.
+type prefixRouter struct {
+	ethdb.KeyValueStore
+	tables []*routedTable
+	meta   *routedTable
+}
+
+func newPrefixRouter(dir string) (*prefixRouter, error) {
+	categories := []struct {
+		name  string
+		match func(key []byte) bool
+	}{
+		{"code", func(key []byte) bool {
+			return len(key) == len(rawdb.CodePrefix)+common.HashLength && bytes.HasPrefix(key, rawdb.CodePrefix)
+		}},
+		{"account", func(key []byte) bool { return bytes.HasPrefix(key, rawdb.TrieNodeAccountPrefix) }},
+		{"storage", func(key []byte) bool { return bytes.HasPrefix(key, rawdb.TrieNodeStoragePrefix) }},
+		{"trie", func(key []byte) bool { return len(key) == common.HashLength }},
+		{"preimage", func(key []byte) bool { return bytes.HasPrefix(key, rawdb.PreimagePrefix) }},
+		{"meta", func(key []byte) bool { return true }},
+	}
+	router := new(prefixRouter)
+	for _, c := range categories {
+		path := filepath.Join(dir, c.name)
+		db, err := leveldb.New(path, 64, 256, "eth/db/"+c.name+"/", false)
+		if err != nil {
+			router.Close()
+			return nil, err
+		}
+		router.tables = append(router.tables, &routedTable{name: c.name, match: c.match, db: db, growth: &growthCurve{path: path}})
+	}
+	router.meta = router.tables[len(router.tables)-1]
+	router.KeyValueStore = router.meta.db
+	return router, nil
+}
+
+func (pr *prefixRouter) route(key []byte) *routedTable {
+	for _, t := range pr.tables {
+		if t.match(key) {
+			return t
+		}
+	}
+	return pr.meta
+}
+
+func (pr *prefixRouter) Has(key []byte) (bool, error)   { return pr.route(key).db.Has(key) }
+func (pr *prefixRouter) Get(key []byte) ([]byte, error) { return pr.route(key).db.Get(key) }
+func (pr *prefixRouter) Delete(key []byte) error        { return pr.route(key).db.Delete(key) }
+
+func (pr *prefixRouter) Put(key []byte, value []byte) error {
+	t := pr.route(key)
+	t.written += int64(len(key) + len(value))
+	return t.db.Put(key, value)
+}
+
+// NewIterator merges the iterations of all tables. Categories are told apart
+// by whole keys, not by prefixes, so the keys under a prefix like
+// rawdb.CodePrefix or an empty one can live in any table.
+func (pr *prefixRouter) NewIterator(prefix []byte, start []byte) ethdb.Iterator {
+	its := make([]ethdb.Iterator, len(pr.tables))
+	for i, t := range pr.tables {
+		its[i] = t.db.NewIterator(prefix, start)
+	}
+	return newMergedIterator(its)
+}
+
+// mergedIterator walks iterators over disjoint key sets as one, in key order.
+type mergedIterator struct {
+	its  []ethdb.Iterator
+	live []bool // whether the iterator is positioned on a key not returned yet
+	cur  int    // iterator of the current key, -1 before the first and after the last
+}
+
+func newMergedIterator(its []ethdb.Iterator) *mergedIterator {
+	mi := &mergedIterator{its: its, live: make([]bool, len(its)), cur: -1}
+	for i, it := range its {
+		mi.live[i] = it.Next()
+	}
+	return mi
+}
+
+func (mi *mergedIterator) Next() bool {
+	if mi.cur >= 0 {
+		mi.live[mi.cur] = mi.its[mi.cur].Next()
+	}
+	mi.cur = -1
+	for i, it := range mi.its {
+		if mi.live[i] && (mi.cur < 0 || bytes.Compare(it.Key(), mi.its[mi.cur].Key()) < 0) {
+			mi.cur = i
+		}
+	}
+	return mi.cur >= 0
+}
+
+func (mi *mergedIterator) Error() error {
+	for _, it := range mi.its {
+		if err := it.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mi *mergedIterator) Key() []byte {
+	if mi.cur < 0 {
+		return nil
+	}
+	return mi.its[mi.cur].Key()
+}
+
+func (mi *mergedIterator) Value() []byte {
+	if mi.cur < 0 {
+		return nil
+	}
+	return mi.its[mi.cur].Value()
+}
+
+func (mi *mergedIterator) Release() {
+	for _, it := range mi.its {
+		it.Release()
+	}
+}
+
+func (pr *prefixRouter) NewBatch() ethdb.Batch {
+	return &routerBatch{Batch: pr.meta.db.NewBatch(), router: pr, batches: make(map[*routedTable]ethdb.Batch)}
+}
+
+func (pr *prefixRouter) NewBatchWithSize(size int) ethdb.Batch {
+	return pr.NewBatch()
+}
+
+func (pr *prefixRouter) Compact(start []byte, limit []byte) error {
+	for _, t := range pr.tables {
+		if err := t.db.Compact(start, limit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (pr *prefixRouter) Close() error {
+	for _, t := range pr.tables {
+		t.db.Close()
+	}
+	return nil
+}
+
+func (pr *prefixRouter) sample(phase string, ops int) {
+	for _, t := range pr.tables {
+		t.growth.sample(phase, ops)
+	}
+}
+
+// report prints the final size, table file count and number of shrinking
+// samples, i.e. observed compactions, per category.
+func (pr *prefixRouter) report() {
+	fmt.Printf("\n--- Data Categories ---\n")
+	fmt.Printf("%-10s %12s %12s %8s %10s\n", "Category", "Written (MB)", "Disk (MB)", "Tables", "Shrinks")
+	for _, t := range pr.tables {
+		var (
+			size    = getDirSize(t.growth.path)
+			tables  int
+			shrinks int
+		)
+		for i, p := range t.growth.points {
+			tables = p.tables
+			if i > 0 && p.size < t.growth.points[i-1].size {
+				shrinks++
+			}
+		}
+		fmt.Printf("%-10s %12.2f %12.2f %8d %10d\n", t.name, float64(t.written)/(1024*1024), float64(size)/(1024*1024), tables, shrinks)
+	}
+}
+
+// routerBatch splits a batch into one batch per routed table. The embedded
+// batch belongs to the meta table.
+type routerBatch struct {
+	ethdb.Batch
+	router  *prefixRouter
+	batches map[*routedTable]ethdb.Batch
+	size    int
+}
+
+func (b *routerBatch) batch(key []byte) (*routedTable, ethdb.Batch) {
+	t := b.router.route(key)
+	if t == b.router.meta {
+		return t, b.Batch
+	}
+	batch, ok := b.batches[t]
+	if !ok {
+		batch = t.db.NewBatch()
+		b.batches[t] = batch
+	}
+	return t, batch
+}
+
+func (b *routerBatch) Put(key []byte, value []byte) error {
+	t, batch := b.batch(key)
+	t.written += int64(len(key) + len(value))
+	b.size += len(key) + len(value)
+	return batch.Put(key, value)
+}
+
+func (b *routerBatch) Delete(key []byte) error {
+	_, batch := b.batch(key)
+	b.size += len(key)
+	return batch.Delete(key)
+}
+
+func (b *routerBatch) ValueSize() int { return b.size }
+
+func (b *routerBatch) Write() error {
+	for _, batch := range b.batches {
+		if err := batch.Write(); err != nil {
+			return err
+		}
+	}
+	return b.Batch.Write()
+}
+
+func (b *routerBatch) Reset() {
+	for _, batch := range b.batches {
+		batch.Reset()
+	}
+	b.Batch.Reset()
+	b.size = 0
+}
+
+func (b *routerBatch) Replay(w ethdb.KeyValueWriter) error {
+	for _, batch := range b.batches {
+		if err := batch.Replay(w); err != nil {
+			return err
+		}
+	}
+	return b.Batch.Replay(w)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"slices"
+	"sort"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+func TestReuseDistances(t *testing.T) {
+	tests := []struct {
+		seq  []uint32
+		want []int
+	}{
+		{nil, nil},
+		{[]uint32{1}, []int{-1}},
+		{[]uint32{1, 1, 1}, []int{-1, 0, 0}},
+		{[]uint32{1, 2, 3, 1}, []int{-1, -1, -1, 2}},
+		{[]uint32{1, 2, 2, 2, 1}, []int{-1, -1, 0, 0, 1}},
+		{[]uint32{1, 2, 1, 2, 3, 2, 1}, []int{-1, -1, 1, 1, -1, 1, 2}},
+	}
+	for i, tt := range tests {
+		if have := collectDistances(tt.seq); !slices.Equal(have, tt.want) {
+			t.Errorf("test %d: have %v, want %v", i, have, tt.want)
+		}
+	}
+}
+
+// TestReuseDistancesRandom checks the Fenwick tree against counting the
+// distinct nodes between two reads of the same node directly.
+func TestReuseDistancesRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for n := 0; n < 20; n++ {
+		seq := make([]uint32, 500)
+		for i := range seq {
+			seq[i] = uint32(r.Intn(1 + n*5))
+		}
+		want := make([]int, len(seq))
+		for i, id := range seq {
+			want[i] = -1
+			seen := make(map[uint32]bool)
+			for j := i - 1; j >= 0; j-- {
+				if seq[j] == id {
+					want[i] = len(seen)
+					break
+				}
+				seen[seq[j]] = true
+			}
+		}
+		if have := collectDistances(seq); !slices.Equal(have, want) {
+			t.Fatalf("alphabet %d: distances mismatch\nhave %v\nwant %v", 1+n*5, have, want)
+		}
+	}
+}
+
+func collectDistances(seq []uint32) []int {
+	var dists []int
+	reuseDistances(seq, func(dist int) { dists = append(dists, dist) })
+	return dists
+}
+
+// TestPrefixRouterIterator checks that iterations see the keys of all tables
+// in order, including trie node hashes that happen to share the code prefix.
+func TestPrefixRouterIterator(t *testing.T) {
+	router, err := newPrefixRouter(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer router.Close()
+
+	var (
+		codeKey   = append(append([]byte{}, rawdb.CodePrefix...), common.HexToHash("0x01").Bytes()...)
+		nodeKey   = append(append([]byte{}, rawdb.CodePrefix...), bytes.Repeat([]byte{0xab}, common.HashLength-1)...)
+		otherNode = bytes.Repeat([]byte{0x01}, common.HashLength)
+		preimage  = append(append([]byte{}, rawdb.PreimagePrefix...), common.HexToHash("0x02").Bytes()...)
+		meta      = []byte("mpt-bench-head")
+		all       = [][]byte{codeKey, nodeKey, otherNode, preimage, meta}
+	)
+	for _, key := range all {
+		if err := router.Put(key, []byte{1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if router.route(codeKey).name != "code" || router.route(nodeKey).name != "trie" {
+		t.Fatalf("keys sharing the code prefix not spread over the code and trie tables")
+	}
+	sorted := slices.Clone(all)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+
+	tests := []struct {
+		prefix, start []byte
+		want          [][]byte
+	}{
+		{nil, nil, sorted},
+		{rawdb.CodePrefix, nil, [][]byte{codeKey, nodeKey}},
+		{rawdb.CodePrefix, nodeKey[1:2], [][]byte{nodeKey}},
+		{rawdb.PreimagePrefix, nil, [][]byte{preimage}},
+		{[]byte("absent"), nil, nil},
+	}
+	for i, tt := range tests {
+		var have [][]byte
+		it := router.NewIterator(tt.prefix, tt.start)
+		for it.Next() {
+			have = append(have, common.CopyBytes(it.Key()))
+		}
+		if err := it.Error(); err != nil {
+			t.Fatalf("test %d: %v", i, err)
+		}
+		it.Release()
+		if !slices.EqualFunc(have, tt.want, bytes.Equal) {
+			t.Errorf("test %d: have keys %x, want %x", i, have, tt.want)
+		}
+	}
+}
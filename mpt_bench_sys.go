@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sysCounters is a reading of the system-wide counters under /proc.
+type sysCounters struct {
+	reads, writes             uint64 // completed block device requests
+	readSectors, writeSectors uint64 // 512 byte sectors
+	cpuBusy, cpuIOWait        uint64 // jiffies
+	cpuTotal                  uint64
+	cachedKB, dirtyKB         uint64
+}
+
+// readSysCounters reads disk, CPU and page cache counters from /proc. Disk
+// counters are summed over the whole disks in /sys/block, leaving out
+// partitions and virtual devices stacked on the disks so no request is
+// counted twice.
+func readSysCounters() (sysCounters, error) {
+	var c sysCounters
+	disks, err := os.ReadFile("/proc/diskstats")
+	if err != nil {
+		return c, err
+	}
+	stat, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return c, err
+	}
+	mem, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return c, err
+	}
+	c.parseDiskstats(string(disks), func(name string) bool {
+		_, err := os.Stat(filepath.Join("/sys/block", name))
+		return err == nil
+	})
+	c.parseStat(string(stat))
+	c.parseMeminfo(string(mem))
+	return c, nil
+}
+
+// parseDiskstats adds up the request and sector counters of the devices in
+// the contents of /proc/diskstats that are whole, physical disks.
+func (c *sysCounters) parseDiskstats(disks string, isDisk func(name string) bool) {
+	for _, line := range strings.Split(disks, "\n") {
+		f := strings.Fields(line)
+		if len(f) < 10 {
+			continue
+		}
+		if strings.HasPrefix(f[2], "loop") || strings.HasPrefix(f[2], "ram") || strings.HasPrefix(f[2], "zram") ||
+			strings.HasPrefix(f[2], "dm-") || strings.HasPrefix(f[2], "md") {
+			continue
+		}
+		if !isDisk(f[2]) {
+			continue
+		}
+		var reads, readSectors, writes, writeSectors uint64
+		fmt.Sscanf(f[3], "%d", &reads)
+		fmt.Sscanf(f[5], "%d", &readSectors)
+		fmt.Sscanf(f[7], "%d", &writes)
+		fmt.Sscanf(f[9], "%d", &writeSectors)
+		c.reads += reads
+		c.readSectors += readSectors
+		c.writes += writes
+		c.writeSectors += writeSectors
+	}
+}
+
+// parseStat reads the aggregate CPU times off the first line of /proc/stat.
+func (c *sysCounters) parseStat(stat string) {
+	cpu, _, _ := strings.Cut(stat, "\n")
+	for i, field := range strings.Fields(cpu) {
+		if i == 0 || i > 8 { // guest time is already part of user time
+			continue
+		}
+		var jiffies uint64
+		fmt.Sscanf(field, "%d", &jiffies)
+		c.cpuTotal += jiffies
+		switch i {
+		case 4: // idle
+		case 5:
+			c.cpuIOWait += jiffies
+		default:
+			c.cpuBusy += jiffies
+		}
+	}
+}
+
+// parseMeminfo reads the page cache and dirty page sizes off /proc/meminfo.
+func (c *sysCounters) parseMeminfo(mem string) {
+	for _, line := range strings.Split(mem, "\n") {
+		fmt.Sscanf(line, "Cached: %d kB", &c.cachedKB)
+		fmt.Sscanf(line, "Dirty: %d kB", &c.dirtyKB)
+	}
+}
+
+// sysSample holds the rates between two readings of the /proc counters.
+type sysSample struct {
+	at                  time.Duration // since sampling started
+	span                time.Duration
+	readMBs, writeMBs   float64
+	readIOPS, writeIOPS float64
+	cpu, iowait         float64 // percent of all CPUs
+	cachedMB, dirtyMB   float64
+}
+
+// sysPhase is the series of samples taken while a phase ran.
+type sysPhase struct {
+	name    string
+	samples []sysSample
+}
+
+// sysSampler reads the /proc counters at a fixed interval from a background
+// goroutine, the way iostat and vmstat do, and files the samples under the
+// phase running at the time. Work between two phases counts towards the
+// earlier one.
+type sysSampler struct {
+	interval time.Duration
+	start    time.Time
+
+	lock   sync.Mutex
+	last   sysCounters
+	lastAt time.Time
+	cur    *sysPhase
+	phases []*sysPhase
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newSysSampler(interval time.Duration) (*sysSampler, error) {
+	first, err := readSysCounters()
+	if err != nil {
+		return nil, err
+	}
+	s := &sysSampler{
+		interval: interval,
+		start:    time.Now(),
+		last:     first,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	s.lastAt = s.start
+	go s.loop()
+	return s, nil
+}
+
+func (s *sysSampler) loop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.lock.Lock()
+			s.sample()
+			s.lock.Unlock()
+		}
+	}
+}
+
+// sample files the rates since the previous reading under the current phase.
+// The caller holds the lock.
+func (s *sysSampler) sample() {
+	now := time.Now()
+	c, err := readSysCounters()
+	if err != nil {
+		return
+	}
+	span := now.Sub(s.lastAt)
+	if s.cur != nil && span >= time.Millisecond {
+		secs := span.Seconds()
+		sample := sysSample{
+			at:        now.Sub(s.start),
+			span:      span,
+			readMBs:   float64(c.readSectors-s.last.readSectors) * 512 / (1024 * 1024) / secs,
+			writeMBs:  float64(c.writeSectors-s.last.writeSectors) * 512 / (1024 * 1024) / secs,
+			readIOPS:  float64(c.reads-s.last.reads) / secs,
+			writeIOPS: float64(c.writes-s.last.writes) / secs,
+			cachedMB:  float64(c.cachedKB) / 1024,
+			dirtyMB:   float64(c.dirtyKB) / 1024,
+		}
+		if total := c.cpuTotal - s.last.cpuTotal; total > 0 {
+			sample.cpu = 100 * float64(c.cpuBusy-s.last.cpuBusy) / float64(total)
+			sample.iowait = 100 * float64(c.cpuIOWait-s.last.cpuIOWait) / float64(total)
+		}
+		s.cur.samples = append(s.cur.samples, sample)
+	}
+	s.last, s.lastAt = c, now
+}
+
+// begin closes the phase being sampled, if any, with a last partial sample
+// and starts filing samples under the next.
+func (s *sysSampler) begin(name string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sample()
+	s.cur = &sysPhase{name: name}
+	s.phases = append(s.phases, s.cur)
+}
+
+// close stops sampling after a last partial sample of the current phase.
+func (s *sysSampler) close() {
+	if s == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	s.sample()
+	s.cur = nil
+}
+
+func (s *sysSampler) report() {
+	if s == nil {
+		return
+	}
+	row := func(label string, x sysSample) {
+		fmt.Printf("%-16s %9.1f %9.1f %9.0f %9.0f %7.1f %7.1f %10.1f %9.1f\n",
+			label, x.readMBs, x.writeMBs, x.readIOPS, x.writeIOPS, x.cpu, x.iowait, x.cachedMB, x.dirtyMB)
+	}
+	fmt.Printf("\n--- System Resources (every %v) ---\n", s.interval)
+	fmt.Printf("%-16s %9s %9s %9s %9s %7s %7s %10s %9s\n", "", "Read MB/s", "Wrt. MB/s", "Read IOPS", "Wrt. IOPS", "CPU %", "IOWait", "Cached MB", "Dirty MB")
+	for _, p := range s.phases {
+		if len(p.samples) == 0 {
+			continue
+		}
+		// Rates average over the phase weighted by span, cache sizes are
+		// taken at its end.
+		var avg sysSample
+		var total time.Duration
+		for _, x := range p.samples {
+			w := x.span.Seconds()
+			avg.readMBs += x.readMBs * w
+			avg.writeMBs += x.writeMBs * w
+			avg.readIOPS += x.readIOPS * w
+			avg.writeIOPS += x.writeIOPS * w
+			avg.cpu += x.cpu * w
+			avg.iowait += x.iowait * w
+			total += x.span
+		}
+		secs := total.Seconds()
+		avg.readMBs, avg.writeMBs = avg.readMBs/secs, avg.writeMBs/secs
+		avg.readIOPS, avg.writeIOPS = avg.readIOPS/secs, avg.writeIOPS/secs
+		avg.cpu, avg.iowait = avg.cpu/secs, avg.iowait/secs
+		last := p.samples[len(p.samples)-1]
+		avg.cachedMB, avg.dirtyMB = last.cachedMB, last.dirtyMB
+
+		row(p.name, avg)
+		for _, x := range p.samples {
+			row(fmt.Sprintf("  %v", x.at.Round(time.Millisecond)), x)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import "testing"
+
+func TestParseDiskstats(t *testing.T) {
+	const diskstats = `   8       0 sda 1000 10 80000 500 2000 20 160000 900 0 1200 1400 0 0 0 0
+   8       1 sda1 900 10 72000 450 1900 20 152000 850 0 1100 1300 0 0 0 0
+ 259       0 nvme0n1 300 0 24000 100 400 0 32000 200 0 250 300 0 0 0 0
+ 253       0 dm-0 1200 0 96000 600 2300 0 184000 1000 0 1300 1600 0 0 0 0
+   7       0 loop0 50 0 400 10 0 0 0 0 0 10 10 0 0 0 0
+   1       0 ram0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0
+   8      16 sdb 7 0
+`
+	disks := map[string]bool{"sda": true, "nvme0n1": true, "dm-0": true, "loop0": true, "ram0": true, "sdb": true}
+	var c sysCounters
+	c.parseDiskstats(diskstats, func(name string) bool { return disks[name] })
+
+	// sda and nvme0n1 only: sda1 is a partition, dm-0 is stacked on the
+	// disks, loop0 and ram0 are virtual and the sdb line is truncated.
+	want := sysCounters{reads: 1300, readSectors: 104000, writes: 2400, writeSectors: 192000}
+	if c != want {
+		t.Errorf("counters mismatch: have %+v, want %+v", c, want)
+	}
+}
+
+func TestParseStat(t *testing.T) {
+	tests := []struct {
+		stat                string
+		busy, iowait, total uint64
+	}{
+		{
+			stat:   "cpu  100 5 50 800 30 2 3 10 7 0\ncpu0 50 2 25 400 15 1 1 5 3 0\n",
+			busy:   100 + 5 + 50 + 2 + 3 + 10,
+			iowait: 30,
+			total:  100 + 5 + 50 + 800 + 30 + 2 + 3 + 10,
+		},
+		{
+			stat:   "cpu  1 2 3 4\n", // kernels before iowait was split out
+			busy:   1 + 2 + 3,
+			iowait: 0,
+			total:  1 + 2 + 3 + 4,
+		},
+		{stat: ""},
+	}
+	for i, tt := range tests {
+		var c sysCounters
+		c.parseStat(tt.stat)
+		if c.cpuBusy != tt.busy || c.cpuIOWait != tt.iowait || c.cpuTotal != tt.total {
+			t.Errorf("test %d: have busy %d, iowait %d, total %d, want %d, %d, %d", i, c.cpuBusy, c.cpuIOWait, c.cpuTotal, tt.busy, tt.iowait, tt.total)
+		}
+	}
+}
+
+func TestParseMeminfo(t *testing.T) {
+	const meminfo = `MemTotal:       16318544 kB
+MemFree:         1254868 kB
+Buffers:          419920 kB
+Cached:          8916340 kB
+SwapCached:         1024 kB
+Dirty:              2048 kB
+Writeback:             0 kB
+`
+	var c sysCounters
+	c.parseMeminfo(meminfo)
+	if c.cachedKB != 8916340 || c.dirtyKB != 2048 {
+		t.Errorf("have cached %d kB, dirty %d kB, want 8916340 kB, 2048 kB", c.cachedKB, c.dirtyKB)
+	}
+}
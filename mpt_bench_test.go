@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// runBench runs the benchmark in-process with the given arguments and returns
+// everything it printed.
+func runBench(t *testing.T, args ...string) string {
+	t.Helper()
+	oldArgs, oldFlags, oldStdout := os.Args, flag.CommandLine, os.Stdout
+	defer func() { os.Args, flag.CommandLine, os.Stdout = oldArgs, oldFlags, oldStdout }()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		io.Copy(&out, r)
+		close(done)
+	}()
+	os.Args = append([]string{"mpt_bench"}, args...)
+	flag.CommandLine = flag.NewFlagSet("mpt_bench", flag.ExitOnError)
+	os.Stdout = w
+	main()
+	w.Close()
+	<-done
+	r.Close()
+	return out.String()
+}
+
+// benchRoot returns the hex root printed after the given label, failing the
+// test with the full output if there is none.
+func benchRoot(t *testing.T, out, label string) string {
+	t.Helper()
+	m := regexp.MustCompile(regexp.QuoteMeta(label) + `\s*([0-9a-f]{64})`).FindStringSubmatch(out)
+	if m == nil {
+		t.Fatalf("no %q in output:\n%s", label, out)
+	}
+	return m[1]
+}
+
+// TestPathSchemeRoot checks that path-scheme runs complete and build the same
+// state as the hash scheme, including a modify run continuing one whose first
+// batch changes nothing.
+func TestPathSchemeRoot(t *testing.T) {
+	tests := [][]string{
+		{"-workload", "slots"},
+		{"-workload", "evm", "-creates", "2"},
+		{"-statedb", "reuse"},
+		{"-separate"},
+	}
+	args := []string{"-n", "30", "-slots", "6", "-m", "12", "-k", "5", "-seed", "9"}
+	for _, extra := range tests {
+		run := append(append(slices.Clone(args), extra...), "-db", filepath.Join(t.TempDir(), "db"))
+		want := benchRoot(t, runBench(t, run...), "Final New Root:")
+		have := benchRoot(t, runBench(t, append(run, "-scheme", "path")...), "Final New Root:")
+		if have != want {
+			t.Errorf("%v: path scheme root %s, hash scheme root %s", extra, have, want)
+		}
+	}
+	db := filepath.Join(t.TempDir(), "db")
+	root := benchRoot(t, runBench(t, append(args, "-scheme", "path", "-db", db)...), "Final New Root:")
+	// The first modify batches of the same seed rewrite the values already
+	// there, so its root must stay put while another seed moves it.
+	out := runBench(t, "modify", "-m", "5", "-k", "5", "-seed", "9", "-scheme", "path", "-db", db)
+	if have := benchRoot(t, out, "Final New Root:"); have != root {
+		t.Errorf("replaying seed 9 moved the root from %s to %s", root, have)
+	}
+	out = runBench(t, "modify", "-m", "5", "-k", "5", "-seed", "10", "-scheme", "path", "-db", db)
+	if have := benchRoot(t, out, "Final New Root:"); have == root {
+		t.Errorf("modify run with seed 10 left the root at %s", root)
+	}
+}
+
+// TestPathSchemeRejects checks the modes pathdb cannot serve are refused
+// before anything is built.
+func TestPathSchemeRejects(t *testing.T) {
+	for _, extra := range [][]string{{"-shards", "2"}, {"-snapshot-reads", "50"}, {"-flush-interval", "1ms"}, {"-flush-size", "1"}} {
+		out := runBench(t, append(extra, "-n", "10", "-slots", "2", "-scheme", "path", "-db", filepath.Join(t.TempDir(), "db"))...)
+		if strings.Contains(out, "Phase 1") || (!strings.Contains(out, "requires") && !strings.Contains(out, "cannot be combined")) {
+			t.Errorf("%v not rejected with the path scheme:\n%s", extra, out)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// compactionScheduler decides when the workload pauses for an explicit full
+// compaction: never in load mode, where LevelDB compacts in the background
+// while writes continue, every few batches in idle mode, and between the
+// phases in gaps mode. Its report sets the time spent compacting against the
+// end-to-end time and the read latency the final layout delivers.
+type compactionScheduler struct {
+	db      ethdb.Compacter
+	mode    string
+	every   int
+	begin   time.Time
+	batches int
+
+	pauses int
+	spent  time.Duration
+	total  time.Duration
+	reads  []int // ns per slot read after the run
+}
+
+func (cs *compactionScheduler) run() error {
+	start := time.Now()
+	if err := cs.db.Compact(nil, nil); err != nil {
+		return err
+	}
+	cs.pauses++
+	cs.spent += time.Since(start)
+	return nil
+}
+
+// batch is called after every committed batch.
+func (cs *compactionScheduler) batch() error {
+	if cs.batches++; cs.mode == "idle" && cs.every > 0 && cs.batches%cs.every == 0 {
+		return cs.run()
+	}
+	return nil
+}
+
+// gap is called between phases.
+func (cs *compactionScheduler) gap() error {
+	if cs.mode == "gaps" {
+		return cs.run()
+	}
+	return nil
+}
+
+// finish stops the end-to-end clock and times 10000 random slot reads at the
+// final root, opening a fresh state per 100 reads.
+func (cs *compactionScheduler) finish(env *benchEnv, addrs []common.Address, nSlots int, r *rand.Rand) error {
+	cs.total = time.Since(cs.begin)
+
+	var view *state.StateDB
+	for i := 0; i < 10000; i++ {
+		if i%100 == 0 {
+			var err error
+			if view, err = state.New(env.root, env.sdb); err != nil {
+				return err
+			}
+		}
+		addr := addrs[r.Intn(len(addrs))]
+		slot := common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("slot-%d", r.Intn(nSlots)))))
+		start := time.Now()
+		view.GetState(addr, slot)
+		cs.reads = append(cs.reads, int(time.Since(start).Nanoseconds()))
+	}
+	return nil
+}
+
+func (cs *compactionScheduler) report() {
+	fmt.Printf("\n--- Compaction (%s) ---\n", cs.mode)
+	fmt.Printf("End-to-End:    %v\n", cs.total.Round(time.Millisecond))
+	fmt.Printf("Compacting:    %v in %d pauses\n", cs.spent.Round(time.Millisecond), cs.pauses)
+	fmt.Printf("Final Reads:   %s ns\n", distribution(cs.reads))
+}
+
+// parseByteSize parses sizes like 512MB or 8GB, plain numbers being bytes.
+func parseByteSize(s string) (uint64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	var (
+		value float64
+		unit  string
+	)
+	if n, _ := fmt.Sscanf(strings.ToUpper(s), "%f%s", &value, &unit); n == 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if value < 0 {
+		return 0, fmt.Errorf("negative size %q", s)
+	}
+	scale := map[string]float64{"": 1, "B": 1, "KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30, "TB": 1 << 40}
+	if _, ok := scale[unit]; !ok {
+		return 0, fmt.Errorf("unknown unit in %q", s)
+	}
+	return uint64(value * scale[unit]), nil
+}
+
+// batchTuner decides when a batch is committed. Without a budget it closes
+// every batch after a fixed number of items, like -k always did. With one it
+// estimates the heap an item holds from the growth over the last batch, and
+// sizes the next batch to fill three quarters of the headroom the budget
+// leaves above the heap that survives a commit, the way a node flushes once
+// its dirty cache reaches its allowance.
+type batchTuner struct {
+	budget  uint64
+	size    int
+	pending int
+	batches uint64 // committed batches
+	sizes   []int
+
+	base, peak uint64 // heap after the last commit and before the current one
+}
+
+func newBatchTuner(size int, budget uint64) *batchTuner {
+	return &batchTuner{size: size, budget: budget, base: heapAlloc()}
+}
+
+// heapAlloc returns the heap in use without forcing a collection.
+func heapAlloc() uint64 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+// full counts an item and reports whether the batch is to be committed.
+func (bt *batchTuner) full(last bool) bool {
+	bt.pending++
+	if bt.pending < bt.size && !last {
+		return false
+	}
+	if bt.budget > 0 {
+		bt.peak = heapAlloc()
+	}
+	return true
+}
+
+// committed closes the batch and sizes the next one.
+func (bt *batchTuner) committed() {
+	bt.sizes = append(bt.sizes, bt.pending)
+	bt.batches++
+	if bt.budget > 0 {
+		perItem := max(bt.peak-min(bt.base, bt.peak), 1) / uint64(bt.pending)
+		bt.base = heapAlloc() // the statedb was just released and collected
+		if bt.base < bt.budget {
+			bt.size = max(int((bt.budget-bt.base)*3/4/max(perItem, 1)), 1)
+		} else {
+			bt.size = 1
+		}
+	}
+	bt.pending = 0
+}
+
+func (bt *batchTuner) report(phase string) {
+	fmt.Printf("%-13s %s items per batch\n", phase+":", distribution(bt.sizes))
+	stride := (len(bt.sizes) + 49) / 50
+	for i, size := range bt.sizes {
+		if i%max(stride, 1) == 0 || i == len(bt.sizes)-1 {
+			fmt.Printf("  batch %6d: %d\n", i+1, size)
+		}
+	}
+}
+
+// heapCurve records the allocated heap every 10k slot writes, to show how a
+// statedb that is never released grows, and enforces an optional cap in MB.
+type heapCurve struct {
+	limit  int
+	ops    int
+	points [][2]int // slot writes, heap MB
+}
+
+func (hc *heapCurve) add(n int) error {
+	before := hc.ops
+	hc.ops += n
+	if before/10000 == hc.ops/10000 {
+		return nil
+	}
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	heap := int(stats.HeapAlloc / (1024 * 1024))
+	hc.points = append(hc.points, [2]int{hc.ops, heap})
+	if hc.limit > 0 && heap > hc.limit {
+		return fmt.Errorf("heap of %d MB exceeds the %d MB cap after %d slot writes", heap, hc.limit, hc.ops)
+	}
+	return nil
+}
+
+func (hc *heapCurve) report() {
+	if len(hc.points) == 0 {
+		return
+	}
+	stride := (len(hc.points) + 49) / 50
+	fmt.Printf("\n--- Heap Growth ---\n")
+	fmt.Printf("%14s %10s %12s\n", "Slot Writes", "Heap (MB)", "Bytes/Write")
+	for i, p := range hc.points {
+		if i%stride == 0 || i == len(hc.points)-1 {
+			fmt.Printf("%14d %10d %12.1f\n", p[0], p[1], float64(p[1])*1024*1024/float64(p[0]))
+		}
+	}
+}
+
+// growthPoint is a single disk usage sample taken after a commit.
+type growthPoint struct {
+	phase  string
+	ops    int // cumulative slot writes at the time of the sample
+	size   int64
+	tables int // number of LevelDB table files
+}
+
+// growthCurve samples the database directory after every commit, so that the
+// growth versus operations can be reported, including the dips and jumps
+// caused by compactions which the final size alone hides.
+type growthCurve struct {
+	path   string
+	points []growthPoint
+}
+
+func (gc *growthCurve) sample(phase string, ops int) {
+	point := growthPoint{phase: phase, ops: ops}
+	filepath.Walk(gc.path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			point.size += info.Size()
+			if filepath.Ext(path) == ".ldb" {
+				point.tables++
+			}
+		}
+		return nil
+	})
+	gc.points = append(gc.points, point)
+}
+
+// report prints the curve, thinned out to at most 50 rows. Samples where the
+// database shrank are always included as they mark compactions.
+func (gc *growthCurve) report() {
+	if len(gc.points) == 0 {
+		return
+	}
+	stride := (len(gc.points) + 49) / 50
+	fmt.Printf("\n--- Disk Growth ---\n")
+	fmt.Printf("%-8s %14s %12s %8s\n", "Phase", "Slot Writes", "Size (MB)", "Tables")
+	var shrinks int
+	for i, p := range gc.points {
+		shrunk := i > 0 && p.size < gc.points[i-1].size
+		if shrunk {
+			shrinks++
+		}
+		if i%stride == 0 || i == len(gc.points)-1 || shrunk {
+			fmt.Printf("%-8s %14d %12.2f %8d\n", p.phase, p.ops, float64(p.size)/(1024*1024), p.tables)
+		}
+	}
+	fmt.Printf("Samples:       %d (%d with shrinking size)\n", len(gc.points), shrinks)
+}
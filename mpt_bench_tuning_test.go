@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint64
+		err  bool
+	}{
+		{in: "", want: 0},
+		{in: "100", want: 100},
+		{in: "100B", want: 100},
+		{in: "4KB", want: 4 << 10},
+		{in: "1.5kb", want: 1536},
+		{in: "512MB", want: 512 << 20},
+		{in: "8GB", want: 8 << 30},
+		{in: "8 GB", want: 8 << 30},
+		{in: "2TB", want: 2 << 40},
+		{in: "0GB", want: 0},
+		{in: "GB", err: true},
+		{in: "8XB", err: true},
+		{in: "-1GB", err: true},
+		{in: "-0.5", err: true},
+		{in: "NaN", err: true},
+		{in: "Inf", err: true},
+	}
+	for _, tt := range tests {
+		have, err := parseByteSize(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: have error %v, want error %t", tt.in, err, tt.err)
+			continue
+		}
+		if have != tt.want {
+			t.Errorf("%q: have %d, want %d", tt.in, have, tt.want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/trie/trienode"
+	"github.com/ethereum/go-ethereum/triedb"
+)
+
+// vectorProof is a Merkle proof of a single key within a test vector bundle.
+// Absent keys carry no value and prove exclusion.
+type vectorProof struct {
+	Key   hexutil.Bytes   `json:"key"`
+	Value hexutil.Bytes   `json:"value,omitempty"`
+	Nodes []hexutil.Bytes `json:"nodes"`
+}
+
+// vectorBundle is one conformance test case: inserting Keys[i] -> Values[i]
+// into an empty trie must yield Root, and every proof must verify against it.
+type vectorBundle struct {
+	Keys   []hexutil.Bytes `json:"keys"`
+	Values []hexutil.Bytes `json:"values"`
+	Root   common.Hash     `json:"root"`
+	Proofs []vectorProof   `json:"proofs"`
+}
+
+// exportVectors writes a test vector bundle per requested size to dir, so that
+// other MPT implementations can check themselves against this one. The keys and
+// values are those of a storage trie of Phase 1: hashed slot keys mapping to
+// RLP-encoded slot values. Each bundle proves up to 64 evenly spread keys plus
+// one key that is not in the trie.
+func exportVectors(dir, sizes string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, field := range strings.Split(sizes, ",") {
+		var size int
+		if _, err := fmt.Sscanf(field, "%d", &size); err != nil || size < 1 {
+			return fmt.Errorf("invalid vector size %q", field)
+		}
+		var (
+			bundle = new(vectorBundle)
+			tr     = trie.NewEmpty(triedb.NewDatabase(rawdb.NewMemoryDatabase(), nil))
+		)
+		for j := 0; j < size; j++ {
+			key := crypto.Keccak256(crypto.Keccak256([]byte(fmt.Sprintf("slot-%d", j))))
+			val := common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("value-%d", j))))
+			blob, _ := rlp.EncodeToBytes(common.TrimLeftZeroes(val[:]))
+			tr.MustUpdate(key, blob)
+			bundle.Keys = append(bundle.Keys, key)
+			bundle.Values = append(bundle.Values, blob)
+		}
+		bundle.Root = tr.Hash()
+
+		prove := func(key, value []byte) error {
+			var proof trienode.ProofList
+			if err := tr.Prove(key, &proof); err != nil {
+				return err
+			}
+			p := vectorProof{Key: key, Value: value}
+			for _, node := range proof {
+				p.Nodes = append(p.Nodes, hexutil.Bytes(node))
+			}
+			bundle.Proofs = append(bundle.Proofs, p)
+			return nil
+		}
+		stride := max(1, size/64)
+		for j := 0; j < size; j += stride {
+			if err := prove(bundle.Keys[j], bundle.Values[j]); err != nil {
+				return err
+			}
+		}
+		if err := prove(crypto.Keccak256([]byte("absent")), nil); err != nil {
+			return err
+		}
+		blob, err := json.MarshalIndent(bundle, "", "  ")
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, fmt.Sprintf("mpt_%d.json", size))
+		if err := os.WriteFile(path, blob, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %d keys, %d proofs, root %x to %s\n", size, len(bundle.Proofs), bundle.Root, path)
+	}
+	return nil
+}
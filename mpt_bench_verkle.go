@@ -0,0 +1,114 @@
+package main
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+)
+
+// Verkle tree layout constants of EIP-6800.
+const (
+	verkleBasicDataLeafKey     = 0
+	verkleCodeHashLeafKey      = 1
+	verkleHeaderStorageOffset  = 64
+	verkleCodeOffset           = 128
+	verkleNodeWidth            = 256
+	verkleCodeChunkSize        = 31
+	verkleWitnessBranchCost    = 1900
+	verkleWitnessChunkCost     = 200
+	verkleSubtreeEditCost      = 3000
+	verkleChunkEditCost        = 500
+	verkleChunkFillCost        = 6200
+	verkleMainStorageOffsetLog = 248 // main storage starts at 256**31
+)
+
+// verkleStem identifies a verkle extension node: the account and tree index
+// its stem is derived from. The actual pedersen hash is irrelevant for gas.
+type verkleStem struct {
+	addr      common.Address
+	treeIndex uint256.Int
+}
+
+// verkleLeaf identifies a single leaf (chunk) below a stem.
+type verkleLeaf struct {
+	stem     verkleStem
+	subIndex byte
+}
+
+// accessEvents models the EIP-4762 access events of a single transaction and
+// accumulates the witness gas they would be charged.
+type accessEvents struct {
+	branchReads, branchWrites map[verkleStem]struct{}
+	chunkReads, chunkWrites   map[verkleLeaf]struct{}
+	gas                       uint64
+}
+
+func newAccessEvents() *accessEvents {
+	return &accessEvents{
+		branchReads:  make(map[verkleStem]struct{}),
+		branchWrites: make(map[verkleStem]struct{}),
+		chunkReads:   make(map[verkleLeaf]struct{}),
+		chunkWrites:  make(map[verkleLeaf]struct{}),
+	}
+}
+
+// touch charges the access of a leaf, write access implying read access. Fill
+// costs apply when a write populates a previously empty leaf.
+func (ae *accessEvents) touch(leaf verkleLeaf, write, fill bool) {
+	if _, ok := ae.branchReads[leaf.stem]; !ok {
+		ae.branchReads[leaf.stem] = struct{}{}
+		ae.gas += verkleWitnessBranchCost
+	}
+	if _, ok := ae.chunkReads[leaf]; !ok {
+		ae.chunkReads[leaf] = struct{}{}
+		ae.gas += verkleWitnessChunkCost
+	}
+	if !write {
+		return
+	}
+	if _, ok := ae.branchWrites[leaf.stem]; !ok {
+		ae.branchWrites[leaf.stem] = struct{}{}
+		ae.gas += verkleSubtreeEditCost
+	}
+	if _, ok := ae.chunkWrites[leaf]; !ok {
+		ae.chunkWrites[leaf] = struct{}{}
+		ae.gas += verkleChunkEditCost
+		if fill {
+			ae.gas += verkleChunkFillCost
+		}
+	}
+}
+
+// touchAccount charges the access of the account header (basic data and code
+// hash), as done for the sender and recipient of a transaction.
+func (ae *accessEvents) touchAccount(addr common.Address, write bool) {
+	stem := verkleStem{addr: addr}
+	ae.touch(verkleLeaf{stem, verkleBasicDataLeafKey}, write, false)
+	ae.touch(verkleLeaf{stem, verkleCodeHashLeafKey}, false, false)
+}
+
+// touchCode charges reading all chunks of a contract code of the given size.
+func (ae *accessEvents) touchCode(addr common.Address, size int) {
+	for chunk := 0; chunk*verkleCodeChunkSize < size; chunk++ {
+		pos := uint256.NewInt(uint64(verkleCodeOffset + chunk))
+		ae.touch(verkleLeafAt(addr, pos), false, false)
+	}
+}
+
+// touchSlot charges the access of a storage slot.
+func (ae *accessEvents) touchSlot(addr common.Address, slot common.Hash, write, fill bool) {
+	pos := new(uint256.Int).SetBytes(slot[:])
+	if pos.LtUint64(verkleCodeOffset - verkleHeaderStorageOffset) {
+		pos.AddUint64(pos, verkleHeaderStorageOffset)
+	} else {
+		pos.Add(pos, new(uint256.Int).Lsh(uint256.NewInt(1), verkleMainStorageOffsetLog))
+	}
+	ae.touch(verkleLeafAt(addr, pos), write, fill)
+}
+
+// verkleLeafAt maps a position in the account's storage layout to its leaf.
+func verkleLeafAt(addr common.Address, pos *uint256.Int) verkleLeaf {
+	var stem verkleStem
+	stem.addr = addr
+	stem.treeIndex.Rsh(pos, 8)
+	return verkleLeaf{stem: stem, subIndex: byte(pos.Uint64() % verkleNodeWidth)}
+}
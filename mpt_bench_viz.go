@@ -0,0 +1,581 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/triedb"
+)
+
+// vizNode is a trie node prepared for rendering.
+type vizNode struct {
+	id       int
+	path     []byte // nibbles from the trie root
+	label    string
+	children []*vizNode
+}
+
+// buildVizTree arranges the nodes of a trie into a tree for rendering,
+// labelling each with its kind, and truncates after limit nodes. Accounts
+// are labelled with their nonce and balance if accounts is set.
+func buildVizTree(tr *trie.Trie, accounts bool, limit int, nextID *int) (*vizNode, bool, error) {
+	var (
+		root  *vizNode
+		stack []*vizNode
+		count int
+		it    = tr.MustNodeIterator(nil)
+	)
+	for it.Next(true) {
+		if count++; count > limit {
+			return root, true, nil
+		}
+		node := &vizNode{id: *nextID, path: common.CopyBytes(it.Path())}
+		*nextID++
+
+		switch hash := it.Hash(); {
+		case it.Leaf():
+			node.label = fmt.Sprintf("value %x..", it.LeafKey()[:4])
+			var acc types.StateAccount
+			if accounts && rlp.DecodeBytes(it.LeafBlob(), &acc) == nil {
+				node.label += fmt.Sprintf("\nnonce=%d balance=%v", acc.Nonce, acc.Balance)
+			}
+		case hash == (common.Hash{}):
+			node.label = "embedded"
+		default:
+			kind := "branch"
+			if elems, _, err := rlp.SplitList(it.NodeBlob()); err == nil {
+				if n, _ := rlp.CountValues(elems); n == 2 {
+					key, _, _ := rlp.SplitString(elems)
+					if kind = "extension"; len(key) > 0 && key[0]>>4 >= 2 {
+						kind = "leaf"
+					}
+				}
+			}
+			node.label = fmt.Sprintf("%s %x..\n%d bytes", kind, hash[:4], len(it.NodeBlob()))
+		}
+		for len(stack) > 0 && !bytes.HasPrefix(node.path, stack[len(stack)-1].path) {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			root = node
+		} else {
+			parent := stack[len(stack)-1]
+			parent.children = append(parent.children, node)
+		}
+		stack = append(stack, node)
+	}
+	return root, false, it.Error()
+}
+
+// nibbles renders the path step from parent to child.
+func (n *vizNode) nibbles(parent *vizNode) string {
+	return hexNibbles(n.path[len(parent.path):])
+}
+
+// hexNibbles renders a nibble path with one hex digit per nibble, leaving out
+// the terminator.
+func hexNibbles(nibbles []byte) string {
+	var sb strings.Builder
+	for _, nibble := range nibbles {
+		if nibble < 16 {
+			fmt.Fprintf(&sb, "%x", nibble)
+		}
+	}
+	return sb.String()
+}
+
+func (n *vizNode) writeDOT(w io.Writer) {
+	fmt.Fprintf(w, "    n%d [label=%q];\n", n.id, n.label)
+	for _, child := range n.children {
+		child.writeDOT(w)
+		fmt.Fprintf(w, "    n%d -> n%d [label=%q];\n", n.id, child.id, child.nibbles(n))
+	}
+}
+
+func (n *vizNode) writeHTML(w io.Writer, step string) {
+	label := strings.ReplaceAll(html.EscapeString(n.label), "\n", " &middot; ")
+	if step != "" {
+		label = fmt.Sprintf("<b>%s</b> %s", step, label)
+	}
+	if len(n.children) == 0 {
+		fmt.Fprintf(w, "<div class=\"leaf\">%s</div>\n", label)
+		return
+	}
+	fmt.Fprintf(w, "<details open><summary>%s</summary>\n", label)
+	for _, child := range n.children {
+		child.writeHTML(w, child.nibbles(n))
+	}
+	fmt.Fprintf(w, "</details>\n")
+}
+
+// visualizeState renders the account trie at the recorded head of an existing
+// benchmark database, and the storage tries of the selected accounts, either
+// as a Graphviz graph or as a collapsible HTML tree. It is meant for small
+// states: every trie is cut off after limit nodes.
+func visualizeState(backend, dbPath, scheme, format, out, storage string, limit int) error {
+	if format != "dot" && format != "html" {
+		return fmt.Errorf("unknown format %q", format)
+	}
+	if out == "" {
+		out = "trie." + format
+	}
+	kvdb, err := openDiskStore(backend, dbPath)
+	if err != nil {
+		return err
+	}
+	diskdb := rawdb.NewDatabase(kvdb)
+	defer diskdb.Close()
+
+	head := readBenchHead(diskdb)
+	if head == nil {
+		return fmt.Errorf("no benchmark state found")
+	}
+	config := triedb.HashDefaults
+	if scheme == "path" {
+		config = pathDefaults
+	}
+	tdb := triedb.NewDatabase(diskdb, config)
+	defer tdb.Close()
+
+	type tree struct {
+		title     string
+		root      *vizNode
+		truncated bool
+	}
+	var (
+		trees  []tree
+		nextID int
+	)
+	accTrie, err := trie.New(trie.StateTrieID(head.Root), tdb)
+	if err != nil {
+		return err
+	}
+	root, truncated, err := buildVizTree(accTrie, true, limit, &nextID)
+	if err != nil {
+		return err
+	}
+	trees = append(trees, tree{fmt.Sprintf("account trie %x", head.Root), root, truncated})
+
+	statedb, err := state.New(head.Root, state.NewDatabase(tdb, nil))
+	if err != nil {
+		return err
+	}
+	for _, field := range strings.Split(storage, ",") {
+		if field == "" {
+			continue
+		}
+		var idx int
+		if _, err := fmt.Sscanf(field, "%d", &idx); err != nil || idx < 0 || uint64(idx) >= head.Accounts {
+			return fmt.Errorf("invalid account index %q", field)
+		}
+		addr := common.BytesToAddress(crypto.Keccak256([]byte(fmt.Sprintf("account-%d", idx)))[:20])
+		st, err := trie.New(trie.StorageTrieID(head.Root, crypto.Keccak256Hash(addr[:]), statedb.GetStorageRoot(addr)), tdb)
+		if err != nil {
+			return err
+		}
+		root, truncated, err := buildVizTree(st, false, limit, &nextID)
+		if err != nil {
+			return err
+		}
+		trees = append(trees, tree{fmt.Sprintf("storage trie of account-%d (%x)", idx, addr), root, truncated})
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	if format == "dot" {
+		fmt.Fprintf(w, "digraph trie {\n  node [shape=box, fontname=\"monospace\"];\n")
+		for i, t := range trees {
+			title := t.title
+			if t.truncated {
+				title += fmt.Sprintf(" (first %d nodes)", limit)
+			}
+			fmt.Fprintf(w, "  subgraph cluster_%d {\n    label=%q;\n", i, title)
+			if t.root != nil {
+				t.root.writeDOT(w)
+			}
+			fmt.Fprintf(w, "  }\n")
+		}
+		fmt.Fprintf(w, "}\n")
+	} else {
+		fmt.Fprintf(w, "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>%s</title>\n", html.EscapeString(dbPath))
+		fmt.Fprintf(w, "<style>body{font-family:monospace} details,.leaf{margin-left:1.5em} summary{cursor:pointer}</style></head><body>\n")
+		for _, t := range trees {
+			fmt.Fprintf(w, "<h3>%s</h3>\n", html.EscapeString(t.title))
+			if t.truncated {
+				fmt.Fprintf(w, "<p>Truncated after %d nodes.</p>\n", limit)
+			}
+			if t.root != nil {
+				t.root.writeHTML(w, "")
+			}
+		}
+		fmt.Fprintf(w, "</body></html>\n")
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("Rendered %d tries at block %d to %s\n", len(trees), head.Block, out)
+	return nil
+}
+
+// explorer serves a read-only web UI over a benchmark database: the accounts
+// and slots of the recorded head state, and the raw trie nodes by hash (hash
+// scheme) or by path (both schemes).
+type explorer struct {
+	diskdb ethdb.Database
+	tdb    *triedb.Database
+	sdb    state.Database
+	head   *benchHead
+	scheme string
+}
+
+// exploreState opens the database at dbPath and serves the explorer on listen
+// until the process is stopped.
+func exploreState(backend, dbPath, scheme, listen string) error {
+	kvdb, err := openDiskStore(backend, dbPath)
+	if err != nil {
+		return err
+	}
+	diskdb := rawdb.NewDatabase(kvdb)
+	defer diskdb.Close()
+
+	head := readBenchHead(diskdb)
+	if head == nil {
+		return fmt.Errorf("no benchmark state found in %s", dbPath)
+	}
+	config := triedb.HashDefaults
+	if scheme == "path" {
+		config = pathDefaults
+	}
+	tdb := triedb.NewDatabase(diskdb, config)
+	defer tdb.Close()
+
+	sdb := state.NewDatabase(tdb, nil)
+	if _, err := state.New(head.Root, sdb); err != nil {
+		return err
+	}
+	ex := &explorer{diskdb: diskdb, tdb: tdb, sdb: sdb, head: head, scheme: scheme}
+	fmt.Printf("Exploring %s (root %x, block %d) on http://%s/\n", dbPath, head.Root, head.Block, listen)
+	return http.ListenAndServe(listen, ex.handler())
+}
+
+// handler routes the explorer pages.
+func (ex *explorer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", ex.serveAccounts)
+	mux.HandleFunc("/account", ex.serveAccount)
+	mux.HandleFunc("/node", ex.serveNode)
+	mux.HandleFunc("/path", ex.servePath)
+	return mux
+}
+
+// state opens the head state for one request. A StateDB caches every object
+// it reads, even on lookups, so one shared by the handlers, which net/http
+// runs concurrently, would race and grow without bound.
+func (ex *explorer) state(w http.ResponseWriter) *state.StateDB {
+	statedb, err := state.New(ex.head.Root, ex.sdb)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	return statedb
+}
+
+// page writes the common header of all explorer pages.
+func (ex *explorer) page(w http.ResponseWriter, title string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>%s</title>", html.EscapeString(title))
+	fmt.Fprintf(w, "<style>body{font-family:monospace} td{padding:0 1em 0 0} .blob{word-break:break-all}</style></head><body>\n")
+	fmt.Fprintf(w, "<p><a href=\"/\">accounts</a> &middot; <a href=\"/path\">account trie root</a> &middot; root %x, block %d, %s scheme</p>\n",
+		ex.head.Root, ex.head.Block, ex.scheme)
+	fmt.Fprintf(w, "<h3>%s</h3>\n", html.EscapeString(title))
+}
+
+// queryInt returns the integer query parameter name, or 0.
+func queryInt(r *http.Request, name string) int {
+	var n int
+	fmt.Sscanf(r.URL.Query().Get(name), "%d", &n)
+	return max(n, 0)
+}
+
+func (ex *explorer) serveAccounts(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	statedb := ex.state(w)
+	if statedb == nil {
+		return
+	}
+	pg := queryInt(r, "page")
+	ex.page(w, fmt.Sprintf("Accounts %d-%d of %d", pg*100, min((pg+1)*100, int(ex.head.Accounts))-1, ex.head.Accounts))
+	fmt.Fprintf(w, "<table><tr><th>#</th><th>Address</th><th>Nonce</th><th>Balance</th><th>Storage Root</th><th>Code</th></tr>\n")
+	for i := pg * 100; i < min((pg+1)*100, int(ex.head.Accounts)); i++ {
+		addr := common.BytesToAddress(crypto.Keccak256([]byte(fmt.Sprintf("account-%d", i)))[:20])
+		fmt.Fprintf(w, "<tr><td>%d</td><td><a href=\"/account?idx=%d\">%x</a></td><td>%d</td><td>%v</td><td>%x</td><td>%d bytes</td></tr>\n",
+			i, i, addr, statedb.GetNonce(addr), statedb.GetBalance(addr), statedb.GetStorageRoot(addr), statedb.GetCodeSize(addr))
+	}
+	fmt.Fprintf(w, "</table>\n<p>")
+	if pg > 0 {
+		fmt.Fprintf(w, "<a href=\"/?page=%d\">previous</a> ", pg-1)
+	}
+	if (pg+1)*100 < int(ex.head.Accounts) {
+		fmt.Fprintf(w, "<a href=\"/?page=%d\">next</a>", pg+1)
+	}
+	fmt.Fprintf(w, "</p></body></html>\n")
+}
+
+func (ex *explorer) serveAccount(w http.ResponseWriter, r *http.Request) {
+	var (
+		idx      = queryInt(r, "idx")
+		pg       = queryInt(r, "page")
+		addr     = common.BytesToAddress(crypto.Keccak256([]byte(fmt.Sprintf("account-%d", idx)))[:20])
+		addrHash = crypto.Keccak256Hash(addr[:])
+		statedb  = ex.state(w)
+	)
+	if statedb == nil {
+		return
+	}
+	ex.page(w, fmt.Sprintf("account-%d %x", idx, addr))
+	fmt.Fprintf(w, "<p>Hashed Key: <a href=\"/path?path=%s\">%x</a> (account trie path)<br>\n", hexNibbles(keyNibbles(addrHash[:])), addrHash)
+	fmt.Fprintf(w, "Nonce: %d<br>Balance: %v<br>Code: %d bytes<br>\n", statedb.GetNonce(addr), statedb.GetBalance(addr), statedb.GetCodeSize(addr))
+	fmt.Fprintf(w, "Storage Root: <a href=\"/path?owner=%x\">%x</a></p>\n", addrHash, statedb.GetStorageRoot(addr))
+
+	fmt.Fprintf(w, "<table><tr><th>#</th><th>Slot</th><th>Value</th><th>Trie Path</th></tr>\n")
+	for j := pg * 100; j < min((pg+1)*100, int(ex.head.Slots)); j++ {
+		slot := common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("slot-%d", j))))
+		slotHash := crypto.Keccak256(slot[:])
+		fmt.Fprintf(w, "<tr><td>%d</td><td>%x</td><td>%x</td><td><a href=\"/path?owner=%x&path=%s\">%x..</a></td></tr>\n",
+			j, slot, statedb.GetState(addr, slot), addrHash, hexNibbles(keyNibbles(slotHash)), slotHash[:4])
+	}
+	fmt.Fprintf(w, "</table>\n<p>")
+	if pg > 0 {
+		fmt.Fprintf(w, "<a href=\"/account?idx=%d&page=%d\">previous</a> ", idx, pg-1)
+	}
+	if (pg+1)*100 < int(ex.head.Slots) {
+		fmt.Fprintf(w, "<a href=\"/account?idx=%d&page=%d\">next</a>", idx, pg+1)
+	}
+	fmt.Fprintf(w, "</p></body></html>\n")
+}
+
+// serveNode shows a trie node looked up by its hash, which only the hash
+// scheme stores nodes under.
+func (ex *explorer) serveNode(w http.ResponseWriter, r *http.Request) {
+	hash := common.HexToHash(r.URL.Query().Get("hash"))
+	ex.page(w, fmt.Sprintf("Node %x", hash))
+	if ex.scheme != "hash" {
+		fmt.Fprintf(w, "<p>The path scheme stores nodes by path, not by hash.</p></body></html>\n")
+		return
+	}
+	blob := rawdb.ReadLegacyTrieNode(ex.diskdb, hash)
+	if len(blob) == 0 {
+		fmt.Fprintf(w, "<p>Not found.</p></body></html>\n")
+		return
+	}
+	ex.renderNode(w, blob, nil, "")
+	fmt.Fprintf(w, "</body></html>\n")
+}
+
+// servePath shows the trie node at a nibble path, within the account trie or
+// within the storage trie of the account whose hashed address is owner.
+func (ex *explorer) servePath(w http.ResponseWriter, r *http.Request) {
+	var (
+		owner = common.HexToHash(r.URL.Query().Get("owner"))
+		path  []byte
+	)
+	for _, c := range r.URL.Query().Get("path") {
+		if n, err := hex.DecodeString("0" + string(c)); err == nil {
+			path = append(path, n[0])
+		}
+	}
+	trieName := "account trie"
+	if owner != (common.Hash{}) {
+		trieName = fmt.Sprintf("storage trie %x..", owner[:4])
+	}
+	ex.page(w, fmt.Sprintf("%s, path [%s]", trieName, hexNibbles(path)))
+	blob, err := ex.nodeAtPath(owner, path)
+	if err != nil {
+		fmt.Fprintf(w, "<p>%s</p></body></html>\n", html.EscapeString(err.Error()))
+		return
+	}
+	ex.renderNode(w, blob, path, fmt.Sprintf("%x", owner))
+	fmt.Fprintf(w, "</body></html>\n")
+}
+
+// nodeAtPath returns the blob of the node at path. The path scheme reads it
+// directly, the hash scheme walks down from the trie root.
+func (ex *explorer) nodeAtPath(owner common.Hash, path []byte) ([]byte, error) {
+	root := ex.head.Root
+	if owner != (common.Hash{}) {
+		tr, err := trie.New(trie.StateTrieID(ex.head.Root), ex.tdb)
+		if err != nil {
+			return nil, err
+		}
+		blob, err := tr.Get(owner[:])
+		if err != nil || len(blob) == 0 {
+			return nil, fmt.Errorf("no account with hash %x", owner)
+		}
+		var acc types.StateAccount
+		if err := rlp.DecodeBytes(blob, &acc); err != nil {
+			return nil, err
+		}
+		root = acc.Root
+	}
+	if ex.scheme == "path" {
+		var blob []byte
+		if owner == (common.Hash{}) {
+			blob = rawdb.ReadAccountTrieNode(ex.diskdb, path)
+		} else {
+			blob = rawdb.ReadStorageTrieNode(ex.diskdb, owner, path)
+		}
+		if len(blob) == 0 {
+			return nil, fmt.Errorf("no node at this path")
+		}
+		return blob, nil
+	}
+	blob := rawdb.ReadLegacyTrieNode(ex.diskdb, root)
+	for pos := 0; pos < len(path); {
+		items, err := splitNode(blob)
+		if err != nil {
+			return nil, err
+		}
+		var child rlpItem
+		switch len(items) {
+		case 17:
+			child = items[path[pos]]
+			pos++
+		case 2:
+			key, _ := compactNibbles(items[0].content)
+			if !bytes.HasPrefix(path[pos:], key) {
+				return nil, fmt.Errorf("no node at this path")
+			}
+			child, pos = items[1], pos+len(key)
+		default:
+			return nil, fmt.Errorf("malformed node")
+		}
+		switch {
+		case child.list:
+			blob = child.raw
+		case len(child.content) == common.HashLength:
+			blob = rawdb.ReadLegacyTrieNode(ex.diskdb, common.BytesToHash(child.content))
+		default:
+			return nil, fmt.Errorf("no node at this path")
+		}
+	}
+	if len(blob) == 0 {
+		return nil, fmt.Errorf("missing node")
+	}
+	return blob, nil
+}
+
+// renderNode shows the decoded RLP of a node, with links to its children by
+// path and, in hash scheme, by hash.
+func (ex *explorer) renderNode(w io.Writer, blob []byte, path []byte, owner string) {
+	items, err := splitNode(blob)
+	if err != nil {
+		fmt.Fprintf(w, "<p>Undecodable node: %v</p>\n", html.EscapeString(err.Error()))
+		return
+	}
+	link := func(step []byte, item rlpItem) string {
+		var links []string
+		if path != nil || owner != "" {
+			links = append(links, fmt.Sprintf("<a href=\"/path?owner=%s&path=%s\">by path</a>", owner, hexNibbles(append(common.CopyBytes(path), step...))))
+		}
+		if ex.scheme == "hash" && !item.list && len(item.content) == common.HashLength {
+			links = append(links, fmt.Sprintf("<a href=\"/node?hash=%x\">by hash</a>", item.content))
+		}
+		return strings.Join(links, " ")
+	}
+	fmt.Fprintf(w, "<p>%d bytes, keccak %x</p>\n<table>\n", len(blob), crypto.Keccak256(blob))
+	switch len(items) {
+	case 17:
+		fmt.Fprintf(w, "<tr><th colspan=3>branch</th></tr>\n")
+		for i, item := range items {
+			if len(item.content) == 0 && !item.list {
+				continue
+			}
+			label, links := fmt.Sprintf("%x", i), ""
+			if i < 16 {
+				links = link([]byte{byte(i)}, item)
+			} else {
+				label = "value"
+			}
+			fmt.Fprintf(w, "<tr><td>%s</td><td class=\"blob\">%x</td><td>%s</td></tr>\n", label, item.raw, links)
+		}
+	case 2:
+		key, leaf := compactNibbles(items[0].content)
+		if leaf {
+			fmt.Fprintf(w, "<tr><th colspan=2>leaf</th></tr>\n<tr><td>key</td><td>[%s]</td></tr>\n", hexNibbles(key))
+			fmt.Fprintf(w, "<tr><td>value</td><td class=\"blob\">%x</td></tr>\n", items[1].content)
+		} else {
+			fmt.Fprintf(w, "<tr><th colspan=3>extension</th></tr>\n<tr><td>key</td><td>[%s]</td><td></td></tr>\n", hexNibbles(key))
+			fmt.Fprintf(w, "<tr><td>child</td><td class=\"blob\">%x</td><td>%s</td></tr>\n", items[1].raw, link(key, items[1]))
+		}
+	}
+	fmt.Fprintf(w, "</table>\n<p>RLP:</p><p class=\"blob\">%x</p>\n", blob)
+}
+
+// rlpItem is an element of an encoded trie node.
+type rlpItem struct {
+	raw     []byte // full encoding
+	content []byte
+	list    bool // an embedded node
+}
+
+// splitNode splits an encoded trie node into its elements.
+func splitNode(blob []byte) ([]rlpItem, error) {
+	elems, _, err := rlp.SplitList(blob)
+	if err != nil {
+		return nil, err
+	}
+	var items []rlpItem
+	for len(elems) > 0 {
+		kind, content, rest, err := rlp.Split(elems)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, rlpItem{raw: elems[:len(elems)-len(rest)], content: content, list: kind == rlp.List})
+		elems = rest
+	}
+	return items, nil
+}
+
+// keyNibbles expands a key into the nibble path leading to it.
+func keyNibbles(key []byte) []byte {
+	nibbles := make([]byte, 0, 2*len(key))
+	for _, b := range key {
+		nibbles = append(nibbles, b>>4, b&0x0f)
+	}
+	return nibbles
+}
+
+// compactNibbles decodes the compact (hex-prefix) encoded key of a short node.
+func compactNibbles(key []byte) ([]byte, bool) {
+	if len(key) == 0 {
+		return nil, false
+	}
+	nibbles := keyNibbles(key)
+	leaf := nibbles[0] >= 2
+	if nibbles[0]&1 == 1 {
+		return nibbles[1:], leaf
+	}
+	return nibbles[2:], leaf
+}
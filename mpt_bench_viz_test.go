@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/triedb"
+)
+
+// TestExplorerConcurrentPages serves the state pages of the explorer to many
+// clients at once, as net/http does, which the race detector flags if the
+// handlers share a StateDB.
+func TestExplorerConcurrentPages(t *testing.T) {
+	db := filepath.Join(t.TempDir(), "db")
+	runBench(t, "-n", "128", "-slots", "8", "-m", "5", "-k", "32", "-seed", "1", "-db", db)
+
+	kvdb, err := openDiskStore("leveldb", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diskdb := rawdb.NewDatabase(kvdb)
+	defer diskdb.Close()
+	head := readBenchHead(diskdb)
+	if head == nil {
+		t.Fatal("no benchmark head recorded")
+	}
+	tdb := triedb.NewDatabase(diskdb, triedb.HashDefaults)
+	defer tdb.Close()
+	ex := &explorer{diskdb: diskdb, tdb: tdb, sdb: state.NewDatabase(tdb, nil), head: head, scheme: "hash"}
+	handler := ex.handler()
+
+	var (
+		wg    sync.WaitGroup
+		start = make(chan struct{})
+		errs  = make(chan error, 128)
+	)
+	for i := 0; i < 128; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			page := "/"
+			if i%2 == 1 {
+				page = fmt.Sprintf("/account?idx=%d", i)
+			}
+			rec := httptest.NewRecorder()
+			<-start
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, page, nil))
+			if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Storage Root") {
+				errs <- fmt.Errorf("%s: status %d:\n%s", page, rec.Code, rec.Body)
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
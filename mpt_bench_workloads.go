@@ -0,0 +1,1017 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+	"runtime"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+// workloads lists the modification workloads that run as a self-contained
+// Phase 2, in addition to the built-in slots and evm loops in main.
+var workloads = map[string]func(env *benchEnv, cfg *workloadConfig) error{
+	"access-list":  runAccessListWorkload,
+	"sstore-churn": runSstoreChurnWorkload,
+	"selfdestruct": runSelfdestructWorkload,
+	"system":       runSystemContractsWorkload,
+	"delegation":   runDelegationWorkload,
+	"precompile":   runPrecompileWorkload,
+	"forks":        runForksWorkload,
+	"copy-cost":    runCopyCostWorkload,
+	"journal":      runJournalWorkload,
+	"builder":      runBuilderWorkload,
+	"code-blobs":   runCodeBlobsWorkload,
+}
+
+// workloadConfig carries the Phase 1 outcome and sizing parameters into a
+// workload, and collects the report lines it wants printed at the end.
+type workloadConfig struct {
+	addrs     []common.Address
+	slots     int
+	txs       int // number of simulated transactions
+	batchSize int // transactions per simulated block
+	forks     int
+	jwrites   int // writes between snapshots in the journal workload
+	cands     int // candidate payloads per slot in the builder workload
+	dbPath    string
+	rand      *rand.Rand
+
+	lines []string
+}
+
+// slotKey returns the storage key of the idx-th slot created in Phase 1.
+func (cfg *workloadConfig) slotKey(idx int) common.Hash {
+	return common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("slot-%d", idx))))
+}
+
+// endOfBlock reports whether the i-th transaction is the last of its block.
+func (cfg *workloadConfig) endOfBlock(i int) bool {
+	return (i+1)%cfg.batchSize == 0 || i+1 == cfg.txs
+}
+
+func (cfg *workloadConfig) printf(format string, args ...interface{}) {
+	cfg.lines = append(cfg.lines, fmt.Sprintf(format, args...))
+}
+
+func (cfg *workloadConfig) report() {
+	for _, line := range cfg.lines {
+		fmt.Println(line)
+	}
+}
+
+// accessList tracks the accounts and slots warmed up within a single simulated
+// transaction, following the EIP-2929 rules.
+type accessList struct {
+	addrs map[common.Address]struct{}
+	slots map[common.Address]map[common.Hash]struct{}
+}
+
+func newAccessList(warm ...common.Address) *accessList {
+	al := &accessList{
+		addrs: make(map[common.Address]struct{}),
+		slots: make(map[common.Address]map[common.Hash]struct{}),
+	}
+	for _, addr := range warm {
+		al.addrs[addr] = struct{}{}
+	}
+	return al
+}
+
+// touchAccount marks addr as accessed and reports whether it was cold.
+func (al *accessList) touchAccount(addr common.Address) bool {
+	if _, ok := al.addrs[addr]; ok {
+		return false
+	}
+	al.addrs[addr] = struct{}{}
+	return true
+}
+
+// touchSlot marks the slot of addr as accessed and reports whether it was cold.
+func (al *accessList) touchSlot(addr common.Address, slot common.Hash) bool {
+	slots := al.slots[addr]
+	if slots == nil {
+		slots = make(map[common.Hash]struct{})
+		al.slots[addr] = slots
+	}
+	if _, ok := slots[slot]; ok {
+		return false
+	}
+	slots[slot] = struct{}{}
+	return true
+}
+
+// runAccessListWorkload simulates transactions that each touch a handful of
+// contracts and slots, reading and occasionally writing them through the
+// statedb. Every access is classified as warm or cold per EIP-2929 and the
+// per-transaction cold-access counts are reported as a distribution, along with
+// what an EIP-2930 access list declaring every cold access would have saved.
+func runAccessListWorkload(env *benchEnv, cfg *workloadConfig) error {
+	var (
+		coldAccounts, coldSlots []int
+		warm, accessGas, saved  uint64
+	)
+	for i := 0; i < cfg.txs; i++ {
+		var (
+			from = cfg.addrs[cfg.rand.Intn(len(cfg.addrs))]
+			to   = cfg.addrs[cfg.rand.Intn(len(cfg.addrs))]
+			al   = newAccessList(from, to)
+
+			nAccounts, nSlots int
+		)
+		// The callee plus up to three nested calls into other contracts
+		callees := []common.Address{to}
+		for n := cfg.rand.Intn(4); n > 0; n-- {
+			callees = append(callees, cfg.addrs[cfg.rand.Intn(len(cfg.addrs))])
+		}
+		for _, addr := range callees {
+			if al.touchAccount(addr) {
+				nAccounts++
+				accessGas += params.ColdAccountAccessCostEIP2929
+			} else {
+				warm++
+				accessGas += params.WarmStorageReadCostEIP2929
+			}
+			env.statedb.GetBalance(addr)
+
+			for j := 0; j < 8; j++ {
+				// Half of the accesses hit a small set of hot slots, so slots
+				// are revisited within the same transaction.
+				idx := cfg.rand.Intn(cfg.slots)
+				if cfg.rand.Intn(2) == 0 {
+					idx = cfg.rand.Intn(min(cfg.slots, 4))
+				}
+				key := cfg.slotKey(idx)
+				if al.touchSlot(addr, key) {
+					nSlots++
+					accessGas += params.ColdSloadCostEIP2929
+				} else {
+					warm++
+					accessGas += params.WarmStorageReadCostEIP2929
+				}
+				val := env.statedb.GetState(addr, key)
+				if cfg.rand.Intn(4) == 0 {
+					val[0]++
+					env.statedb.SetState(addr, key, val)
+				}
+			}
+		}
+		coldAccounts = append(coldAccounts, nAccounts)
+		coldSlots = append(coldSlots, nSlots)
+		// A declared key still pays the warm access on its first use.
+		saved += uint64(nAccounts)*(params.ColdAccountAccessCostEIP2929-params.TxAccessListAddressGas-params.WarmStorageReadCostEIP2929) +
+			uint64(nSlots)*(params.ColdSloadCostEIP2929-params.TxAccessListStorageKeyGas-params.WarmStorageReadCostEIP2929)
+
+		if cfg.endOfBlock(i) {
+			if err := env.endBlock(); err != nil {
+				return err
+			}
+		}
+	}
+	var cold uint64
+	for i := range coldAccounts {
+		cold += uint64(coldAccounts[i] + coldSlots[i])
+	}
+	cfg.printf("Transactions:  %d", cfg.txs)
+	cfg.printf("Accesses:      %d cold, %d warm (%.1f%% cold)", cold, warm, float64(cold)/float64(cold+warm)*100)
+	cfg.printf("Cold Accounts: %s per tx", distribution(coldAccounts))
+	cfg.printf("Cold Slots:    %s per tx", distribution(coldSlots))
+	cfg.printf("Access Gas:    %d (EIP-2929), %d saved by EIP-2930 access lists", accessGas, saved)
+	return nil
+}
+
+// sstoreGas returns the gas and refund of an SSTORE changing a slot from
+// current to value, given its original value at the start of the transaction,
+// following the net gas metering of EIP-2200 as amended by EIP-2929 and
+// EIP-3529. The cold access surcharge is not included.
+func sstoreGas(original, current, value common.Hash) (gas uint64, refund int64) {
+	clearRefund := int64(params.SstoreClearsScheduleRefundEIP3529)
+	if current == value {
+		return params.WarmStorageReadCostEIP2929, 0
+	}
+	if original == current {
+		if original == (common.Hash{}) {
+			return params.SstoreSetGasEIP2200, 0
+		}
+		if value == (common.Hash{}) {
+			refund += clearRefund
+		}
+		return params.SstoreResetGasEIP2200 - params.ColdSloadCostEIP2929, refund
+	}
+	if original != (common.Hash{}) {
+		if current == (common.Hash{}) {
+			refund -= clearRefund
+		} else if value == (common.Hash{}) {
+			refund += clearRefund
+		}
+	}
+	if original == value {
+		if original == (common.Hash{}) {
+			refund += int64(params.SstoreSetGasEIP2200 - params.WarmStorageReadCostEIP2929)
+		} else {
+			refund += int64(params.SstoreResetGasEIP2200 - params.ColdSloadCostEIP2929 - params.WarmStorageReadCostEIP2929)
+		}
+	}
+	return params.WarmStorageReadCostEIP2929, refund
+}
+
+// runSstoreChurnWorkload flips a small set of hot slots per contract between
+// zero and non-zero values, both within a transaction (X -> 0 -> X, which nets
+// out) and across blocks (X -> 0, later 0 -> X). Gas and refunds are metered per
+// EIP-2200/3529, and at every commit the written slots are split into ones that
+// really changed the storage trie and ones that ended the block at their
+// starting value, i.e. writes that were no-ops from the trie's point of view.
+func runSstoreChurnWorkload(env *benchEnv, cfg *workloadConfig) error {
+	type slotRef struct {
+		addr common.Address
+		key  common.Hash
+	}
+	var (
+		hot = min(cfg.slots, 16)
+
+		writes, trieWrites, noops, clears, sets uint64
+		gasUsed, refunded                       uint64
+		commitTime                              time.Duration
+
+		blockStart = make(map[slotRef]common.Hash) // slot values at the start of the block
+	)
+	for i := 0; i < cfg.txs; i++ {
+		var (
+			addr     = cfg.addrs[cfg.rand.Intn(len(cfg.addrs))]
+			original = make(map[common.Hash]common.Hash) // slot values at the start of the transaction
+			gas      = params.TxGas
+			refund   int64
+		)
+		write := func(key, value common.Hash) {
+			current := env.statedb.GetState(addr, key)
+			if _, ok := original[key]; !ok {
+				original[key] = current
+				gas += params.ColdSloadCostEIP2929
+			}
+			if _, ok := blockStart[slotRef{addr, key}]; !ok {
+				blockStart[slotRef{addr, key}] = current
+			}
+			g, r := sstoreGas(original[key], current, value)
+			gas, refund = gas+g, refund+r
+			env.statedb.SetState(addr, key, value)
+			writes++
+		}
+		for j := 0; j < 8; j++ {
+			key := cfg.slotKey(cfg.rand.Intn(hot))
+			current := env.statedb.GetState(addr, key)
+			switch {
+			case current == (common.Hash{}):
+				// Re-populate a slot cleared earlier
+				write(key, common.BytesToHash(crypto.Keccak256(key[:], []byte{byte(i)})))
+			case cfg.rand.Intn(2) == 0:
+				// Clear the slot and restore it within the same transaction
+				write(key, common.Hash{})
+				write(key, current)
+			default:
+				// Clear the slot, to be re-populated by a later transaction
+				write(key, common.Hash{})
+			}
+		}
+		// EIP-3529 caps the refund at a fifth of the gas used
+		if refund < 0 {
+			refund = 0
+		}
+		if uint64(refund) > gas/params.RefundQuotientEIP3529 {
+			refund = int64(gas / params.RefundQuotientEIP3529)
+		}
+		gasUsed += gas - uint64(refund)
+		refunded += uint64(refund)
+		env.statedb.Finalise(true)
+
+		if cfg.endOfBlock(i) {
+			for ref, before := range blockStart {
+				after := env.statedb.GetState(ref.addr, ref.key)
+				switch {
+				case after == before:
+					noops++
+				case after == (common.Hash{}):
+					trieWrites++
+					clears++
+				case before == (common.Hash{}):
+					trieWrites++
+					sets++
+				default:
+					trieWrites++
+				}
+			}
+			clear(blockStart)
+
+			start := time.Now()
+			if err := env.endBlock(); err != nil {
+				return err
+			}
+			commitTime += time.Since(start)
+		}
+	}
+	cfg.printf("Transactions:  %d", cfg.txs)
+	cfg.printf("SSTOREs:       %d", writes)
+	cfg.printf("Slots Touched: %d trie updates (%d deletions, %d insertions), %d net no-ops", trieWrites, clears, sets, noops)
+	cfg.printf("Gas Used:      %d after %d refunded", gasUsed, refunded)
+	cfg.printf("Commit Time:   %v", commitTime)
+	return nil
+}
+
+// runSelfdestructWorkload deploys contracts and self-destructs them, cycling
+// through three kinds of blocks:
+//
+//   - same-tx: every transaction deploys a contract and destroys it right away,
+//     which per EIP-6780 removes the account entirely
+//   - deploy: every transaction deploys a contract that survives the block
+//   - cross-tx: every transaction calls SELFDESTRUCT on a contract from an
+//     earlier deploy block, which post-Cancun only sweeps its balance
+//
+// After every commit the resulting state is checked against those semantics,
+// and execution and commit time are reported per block kind.
+func runSelfdestructWorkload(env *benchEnv, cfg *workloadConfig) error {
+	const (
+		sameTx = iota
+		deploy
+		crossTx
+	)
+	var (
+		names   = []string{"same-tx", "deploy", "cross-tx"}
+		txTime  [3]time.Duration
+		txCount [3]int
+		commits [3]time.Duration
+		blocks  [3]int
+		gasUsed [3]uint64
+
+		deployed   []common.Address // contracts awaiting a cross-tx destruct
+		destructed []common.Address // contracts touched in the current block
+		violations int
+	)
+	create := func(i int) common.Address {
+		addr := common.BytesToAddress(crypto.Keccak256([]byte(fmt.Sprintf("selfdestruct-%d", i)))[:20])
+		env.statedb.CreateAccount(addr)
+		env.statedb.CreateContract(addr)
+		env.statedb.SetNonce(addr, 1, tracing.NonceChangeUnspecified)
+		env.statedb.SetCode(addr, selfdestructCode)
+		env.statedb.SetBalance(addr, uint256.NewInt(1e9), tracing.BalanceChangeUnspecified)
+		for j := 0; j < 4; j++ {
+			env.statedb.SetState(addr, cfg.slotKey(j), common.BytesToHash([]byte{byte(j + 1)}))
+		}
+		return addr
+	}
+	for i := 0; i < cfg.txs; i++ {
+		kind := int(env.chain.number) % 3
+		if kind == crossTx && len(deployed) == 0 {
+			kind = deploy // nothing left to destroy, deploy some more instead
+		}
+		start := time.Now()
+		switch kind {
+		case sameTx:
+			addr := create(i)
+			used, err := env.call(addr, nil)
+			if err != nil {
+				return err
+			}
+			gasUsed[kind] += used
+			destructed = append(destructed, addr)
+		case deploy:
+			deployed = append(deployed, create(i))
+		case crossTx:
+			addr := deployed[0]
+			deployed = deployed[1:]
+			used, err := env.call(addr, nil)
+			if err != nil {
+				return err
+			}
+			gasUsed[kind] += used
+			destructed = append(destructed, addr)
+		}
+		env.statedb.Finalise(true)
+		txTime[kind] += time.Since(start)
+		txCount[kind]++
+
+		if cfg.endOfBlock(i) {
+			start := time.Now()
+			if err := env.endBlock(); err != nil {
+				return err
+			}
+			commits[kind] += time.Since(start)
+			blocks[kind]++
+
+			// Verify the EIP-6780 semantics against the freshly committed state
+			for _, addr := range destructed {
+				exists := env.statedb.Exist(addr)
+				switch {
+				case kind == sameTx && exists:
+					violations++
+				case kind == crossTx && (!exists || env.statedb.GetCodeSize(addr) == 0 || !env.statedb.GetBalance(addr).IsZero() ||
+					env.statedb.GetState(addr, cfg.slotKey(0)) == (common.Hash{})):
+					violations++
+				}
+			}
+			destructed = destructed[:0]
+		}
+	}
+	for kind, name := range names {
+		if txCount[kind] == 0 {
+			continue
+		}
+		line := fmt.Sprintf("%-9s      %d txs, %v per tx", name+":", txCount[kind], txTime[kind]/time.Duration(txCount[kind]))
+		if blocks[kind] > 0 {
+			line += fmt.Sprintf(", %v per commit", commits[kind]/time.Duration(blocks[kind]))
+		}
+		if gasUsed[kind] > 0 {
+			line += fmt.Sprintf(", %d gas per tx", gasUsed[kind]/uint64(txCount[kind]))
+		}
+		cfg.printf("%s", line)
+	}
+	cfg.printf("EIP-6780:      %d violations", violations)
+	return nil
+}
+
+// historyBufferLength is the ring buffer size of both the EIP-4788 beacon roots
+// and the EIP-2935 block hash history contracts.
+const historyBufferLength = 8191
+
+// runSystemContractsWorkload emulates the per-block writes of the EIP-4788
+// beacon roots and EIP-2935 history contracts: every simulated block stores
+// its timestamp and beacon root, and its parent hash, into ring buffers that
+// wrap after historyBufferLength blocks. A block holds batchSize light user
+// transactions next to the system writes. Disk usage is sampled periodically
+// so the growth while the buffers fill up can be compared to the steady state
+// where every write replaces an existing slot.
+func runSystemContractsWorkload(env *benchEnv, cfg *workloadConfig) error {
+	var (
+		blocks     = (cfg.txs + cfg.batchSize - 1) / cfg.batchSize
+		sampleStep = max(blocks/20, 1)
+
+		systemTime, commitTime time.Duration
+		lastSize               = getDirSize(cfg.dbPath)
+		fillGrowth, wrapGrowth int64
+		fillBlocks, wrapBlocks int
+	)
+	for _, addr := range []common.Address{params.BeaconRootsAddress, params.HistoryStorageAddress} {
+		if !env.statedb.Exist(addr) {
+			env.statedb.CreateAccount(addr)
+			env.statedb.SetNonce(addr, 1, tracing.NonceChangeUnspecified)
+		}
+	}
+	for b := 0; b < blocks; b++ {
+		number, timestamp := env.chain.number, env.chain.time
+
+		// System calls at the start of the block
+		start := time.Now()
+		idx := timestamp % historyBufferLength
+		beaconRoot := crypto.Keccak256Hash([]byte(fmt.Sprintf("beacon-root-%d", number)))
+		env.statedb.SetState(params.BeaconRootsAddress, common.BigToHash(new(big.Int).SetUint64(idx)), common.BigToHash(new(big.Int).SetUint64(timestamp)))
+		env.statedb.SetState(params.BeaconRootsAddress, common.BigToHash(new(big.Int).SetUint64(idx+historyBufferLength)), beaconRoot)
+
+		parentHash := crypto.Keccak256Hash([]byte(fmt.Sprintf("block-hash-%d", number-1)))
+		env.statedb.SetState(params.HistoryStorageAddress, common.BigToHash(new(big.Int).SetUint64((number-1)%historyBufferLength)), parentHash)
+		env.statedb.Finalise(true)
+		systemTime += time.Since(start)
+
+		// Light user activity filling the rest of the block
+		for i := b * cfg.batchSize; i < min((b+1)*cfg.batchSize, cfg.txs); i++ {
+			addr := cfg.addrs[cfg.rand.Intn(len(cfg.addrs))]
+			env.statedb.SetState(addr, cfg.slotKey(cfg.rand.Intn(cfg.slots)), crypto.Keccak256Hash([]byte(fmt.Sprintf("system-tx-%d", i))))
+		}
+		start = time.Now()
+		if err := env.endBlock(); err != nil {
+			return err
+		}
+		commitTime += time.Since(start)
+
+		if (b+1)%sampleStep == 0 || b+1 == blocks {
+			// Block n writes slot n-1 of the history buffer, so the slots of
+			// later blocks replace those of blocks historyBufferLength before
+			// them, whether this run or an earlier one wrote those.
+			size := getDirSize(cfg.dbPath)
+			if number <= historyBufferLength {
+				fillGrowth += size - lastSize
+				fillBlocks += sampleStep
+			} else {
+				wrapGrowth += size - lastSize
+				wrapBlocks += sampleStep
+			}
+			cfg.printf("Block %8d:  disk %.2f MB", number, float64(size)/(1024*1024))
+			lastSize = size
+		}
+	}
+	cfg.printf("Blocks:        %d (ring buffer wraps after %d)", blocks, historyBufferLength)
+	cfg.printf("System Writes: %v total, %v per block", systemTime, systemTime/time.Duration(max(blocks, 1)))
+	cfg.printf("Commit Time:   %v total, %v per block", commitTime, commitTime/time.Duration(max(blocks, 1)))
+	if fillBlocks > 0 {
+		cfg.printf("Disk Growth:   %.2f KB per block while filling", float64(fillGrowth)/1024/float64(fillBlocks))
+	}
+	if wrapBlocks > 0 {
+		cfg.printf("Disk Growth:   %.2f KB per block after wrapping", float64(wrapGrowth)/1024/float64(wrapBlocks))
+	}
+	return nil
+}
+
+// runDelegationWorkload emulates EIP-7702 set-code transactions: each simulated
+// transaction carries a batch of authorizations that either install a
+// delegation designator on an EOA or clear an existing one, bumping the
+// authority's nonce as the protocol does. The committed state is checked for
+// the expected designators, and the commit cost of this new kind of account
+// mutation is reported next to the code bytes it adds to the database.
+func runDelegationWorkload(env *benchEnv, cfg *workloadConfig) error {
+	const authsPerTx = 16
+
+	var (
+		targets   = cfg.addrs[:min(len(cfg.addrs), 8)] // delegation targets
+		delegated = make(map[common.Address]common.Address)
+
+		sets, clears, violations int
+		commitTime               time.Duration
+		sizeBefore               = getDirSize(cfg.dbPath)
+	)
+	for i := 0; i < cfg.txs; i++ {
+		for j := 0; j < authsPerTx; j++ {
+			authority := common.BytesToAddress(crypto.Keccak256([]byte(fmt.Sprintf("eoa-%d", cfg.rand.Intn(len(cfg.addrs)*authsPerTx))))[:20])
+			nonce := env.statedb.GetNonce(authority)
+			if _, ok := delegated[authority]; ok && cfg.rand.Intn(2) == 0 {
+				// Delegating to the zero address clears the designator
+				env.statedb.SetCode(authority, nil)
+				delete(delegated, authority)
+				clears++
+			} else {
+				target := targets[cfg.rand.Intn(len(targets))]
+				env.statedb.SetCode(authority, types.AddressToDelegation(target))
+				delegated[authority] = target
+				sets++
+			}
+			env.statedb.SetNonce(authority, nonce+1, tracing.NonceChangeUnspecified)
+		}
+		env.statedb.Finalise(true)
+
+		if cfg.endOfBlock(i) {
+			start := time.Now()
+			if err := env.endBlock(); err != nil {
+				return err
+			}
+			commitTime += time.Since(start)
+		}
+	}
+	for authority, target := range delegated {
+		got, ok := types.ParseDelegation(env.statedb.GetCode(authority))
+		if !ok || got != target {
+			violations++
+		}
+	}
+	blocks := (cfg.txs + cfg.batchSize - 1) / cfg.batchSize
+	cfg.printf("Authorizations: %d set, %d cleared, %d delegated at the end", sets, clears, len(delegated))
+	cfg.printf("Commit Time:   %v total, %v per block", commitTime, commitTime/time.Duration(max(blocks, 1)))
+	cfg.printf("Disk Growth:   %.2f MB", float64(getDirSize(cfg.dbPath)-sizeBefore)/(1024*1024))
+	cfg.printf("Designators:   %d mismatches", violations)
+	return nil
+}
+
+// runPrecompileWorkload executes transactions that each call one of the
+// ecrecover, sha256 and modexp precompiles, with the sender's nonce bump as
+// the only state write. It is the execution-bound control for the evm workload:
+// the Mgas/s gap between the two is what state access costs.
+func runPrecompileWorkload(env *benchEnv, cfg *workloadConfig) error {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return err
+	}
+	hash := crypto.Keccak256([]byte("precompile"))
+	sig, err := crypto.Sign(hash, key)
+	if err != nil {
+		return err
+	}
+	ecrecoverInput := make([]byte, 128)
+	copy(ecrecoverInput, hash)
+	ecrecoverInput[63] = sig[64] + 27
+	copy(ecrecoverInput[64:], sig[:64])
+
+	sha256Input := make([]byte, 1024)
+	cfg.rand.Read(sha256Input)
+
+	modexpInput := make([]byte, 96+3*32)
+	for i := 0; i < 3; i++ {
+		modexpInput[i*32+31] = 32 // base, exponent and modulus lengths
+	}
+	cfg.rand.Read(modexpInput[96:])
+
+	var (
+		calls = []struct {
+			name  string
+			addr  common.Address
+			input []byte
+		}{
+			{"ecrecover", common.BytesToAddress([]byte{0x01}), ecrecoverInput},
+			{"sha256", common.BytesToAddress([]byte{0x02}), sha256Input},
+			{"modexp", common.BytesToAddress([]byte{0x05}), modexpInput},
+		}
+		times    = make([]time.Duration, len(calls))
+		counts   = make([]int, len(calls))
+		gas      gasMeter
+		blockGas uint64
+		blockBeg = time.Now()
+	)
+	for i := 0; i < cfg.txs; i++ {
+		c := i % len(calls)
+		from := cfg.addrs[i%len(cfg.addrs)]
+
+		start := time.Now()
+		used, err := env.call(calls[c].addr, calls[c].input)
+		if err != nil {
+			return fmt.Errorf("%s: %v", calls[c].name, err)
+		}
+		env.statedb.SetNonce(from, env.statedb.GetNonce(from)+1, tracing.NonceChangeUnspecified)
+		times[c] += time.Since(start)
+		counts[c]++
+		blockGas += used
+
+		if cfg.endOfBlock(i) {
+			if err := env.endBlock(); err != nil {
+				return err
+			}
+			gas.add(blockGas, time.Since(blockBeg))
+			blockGas, blockBeg = 0, time.Now()
+		}
+	}
+	for c, call := range calls {
+		if counts[c] > 0 {
+			cfg.printf("%-10s     %d calls, %v per call", call.name+":", counts[c], times[c]/time.Duration(counts[c]))
+		}
+	}
+	cfg.printf("Gas Used:      %d over %d blocks", gas.gas, gas.blocks)
+	cfg.printf("Throughput:    %.2f Mgas/s", mgasPerSec(gas.gas, gas.elapsed))
+	return nil
+}
+
+// liveHeap returns the heap in use after a full garbage collection.
+func liveHeap() uint64 {
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+// runForksWorkload models simulation-heavy RPC services: every round copies
+// the statedb at the current root into cfg.forks forks, applies divergent
+// modifications to each one (batchSize transactions writing 50 slots apiece),
+// then keeps a single fork and commits it while discarding the rest. The cost
+// of Copy() and the heap held while all forks are alive are reported, the
+// latter relative to the raw size of the slots written.
+func runForksWorkload(env *benchEnv, cfg *workloadConfig) error {
+	var (
+		rounds      = (cfg.txs + cfg.batchSize - 1) / cfg.batchSize
+		copyTimes   []int // ns per Copy()
+		forkHeap    []int // heap bytes per live fork
+		amplify     []float64
+		modifyTimes time.Duration
+	)
+	for round := 0; round < rounds; round++ {
+		var (
+			base   = liveHeap()
+			forks  = make([]*state.StateDB, cfg.forks)
+			txs    = min(cfg.batchSize, cfg.txs-round*cfg.batchSize)
+			logged int // bytes of slot keys and values written across all forks
+		)
+		for f := range forks {
+			start := time.Now()
+			forks[f] = env.statedb.Copy()
+			copyTimes = append(copyTimes, int(time.Since(start).Nanoseconds()))
+		}
+		start := time.Now()
+		for f, fork := range forks {
+			for t := 0; t < txs; t++ {
+				addr := cfg.addrs[cfg.rand.Intn(len(cfg.addrs))]
+				for j := 0; j < 50; j++ {
+					val := crypto.Keccak256Hash([]byte(fmt.Sprintf("fork-%d-%d-%d-%d", round, f, t, j)))
+					fork.SetState(addr, cfg.slotKey(cfg.rand.Intn(cfg.slots)), val)
+					logged += 2 * common.HashLength
+				}
+			}
+		}
+		modifyTimes += time.Since(start)
+
+		var held uint64
+		if after := liveHeap(); after > base {
+			held = after - base
+		}
+		forkHeap = append(forkHeap, int(held)/len(forks))
+		if logged > 0 {
+			amplify = append(amplify, float64(held)/float64(logged))
+		}
+		// Keep the first fork as the winner, the rest go to the garbage collector
+		env.statedb = forks[0]
+		if err := env.endBlock(); err != nil {
+			return err
+		}
+	}
+	var avgAmplify float64
+	for _, a := range amplify {
+		avgAmplify += a / float64(len(amplify))
+	}
+	cfg.printf("Rounds:        %d x %d forks, %d txs per fork", rounds, cfg.forks, cfg.batchSize)
+	cfg.printf("Copy():        %s ns", distribution(copyTimes))
+	cfg.printf("Modification:  %v total", modifyTimes)
+	cfg.printf("Heap per Fork: %s bytes", distribution(forkHeap))
+	cfg.printf("Amplification: %.1fx heap per byte of slot data written", avgAmplify)
+	return nil
+}
+
+// runBuilderWorkload simulates a block builder: every slot it evaluates B
+// candidate payloads, each one a copy of the current state with its own
+// bundle (batchSize transactions writing 10 slots apiece) applied and hashed
+// via IntermediateRoot. Only a single winner is committed, the rest are
+// discarded. B doubles from 1 up to cfg.cands, the transactions being split
+// evenly across the steps, so the table shows how the cost of a slot scales
+// with the number of candidates.
+func runBuilderWorkload(env *benchEnv, cfg *workloadConfig) error {
+	var steps []int
+	for b := 1; b <= cfg.cands; b *= 2 {
+		steps = append(steps, b)
+	}
+	if len(steps) == 0 {
+		return fmt.Errorf("need at least one candidate per slot, have %d", cfg.cands)
+	}
+	rounds := max(1, (cfg.txs+cfg.batchSize-1)/cfg.batchSize/len(steps))
+
+	cfg.printf("%10s %8s %12s %12s %12s %12s", "Candidates", "Slots", "Copy (µs)", "Apply (µs)", "Root (µs)", "Slot (ms)")
+	var slot int
+	for _, b := range steps {
+		var copyTime, applyTime, rootTime, slotTime time.Duration
+		for round := 0; round < rounds; round++ {
+			var (
+				slotStart  = time.Now()
+				candidates = make([]*state.StateDB, b)
+			)
+			for c := range candidates {
+				start := time.Now()
+				candidate := env.statedb.Copy()
+				copyTime += time.Since(start)
+
+				start = time.Now()
+				for t := 0; t < cfg.batchSize; t++ {
+					addr := cfg.addrs[cfg.rand.Intn(len(cfg.addrs))]
+					for j := 0; j < 10; j++ {
+						val := crypto.Keccak256Hash([]byte(fmt.Sprintf("builder-%d-%d-%d-%d", slot, c, t, j)))
+						candidate.SetState(addr, cfg.slotKey(cfg.rand.Intn(cfg.slots)), val)
+					}
+				}
+				applyTime += time.Since(start)
+
+				start = time.Now()
+				candidate.IntermediateRoot(true)
+				rootTime += time.Since(start)
+				candidates[c] = candidate
+			}
+			slotTime += time.Since(slotStart)
+
+			// Any candidate could win the auction, the rest are thrown away
+			env.statedb = candidates[cfg.rand.Intn(b)]
+			if err := env.endBlock(); err != nil {
+				return err
+			}
+			slot++
+		}
+		payloads := time.Duration(b * rounds)
+		cfg.printf("%10d %8d %12d %12d %12d %12.2f", b, rounds,
+			(copyTime / payloads).Microseconds(), (applyTime / payloads).Microseconds(), (rootTime / payloads).Microseconds(),
+			float64((slotTime/time.Duration(rounds)).Microseconds())/1000)
+	}
+	return nil
+}
+
+// runCopyCostWorkload measures StateDB.Copy() as a function of the number of
+// dirty state objects and the storage they carry, both with the changes still
+// dirty (mid-transaction) and after Finalise moved them to pending storage
+// (between transactions), which is what block builders copy per bundle.
+func runCopyCostWorkload(env *benchEnv, cfg *workloadConfig) error {
+	const repeats = 10
+
+	cfg.printf("%8s %8s %14s %14s", "Objects", "Slots", "Dirty (µs)", "Pending (µs)")
+	for _, objects := range []int{1, 10, 100, 1000, 10000} {
+		if objects > len(cfg.addrs) {
+			break
+		}
+		for _, slots := range []int{0, 16, 256} {
+			if slots > cfg.slots {
+				break
+			}
+			statedb, err := state.New(env.root, env.sdb)
+			if err != nil {
+				return err
+			}
+			for i := 0; i < objects; i++ {
+				addr := cfg.addrs[i]
+				statedb.SetNonce(addr, statedb.GetNonce(addr)+1, tracing.NonceChangeUnspecified)
+				for j := 0; j < slots; j++ {
+					statedb.SetState(addr, cfg.slotKey(j), crypto.Keccak256Hash([]byte(fmt.Sprintf("copy-%d-%d", i, j))))
+				}
+			}
+			measure := func() time.Duration {
+				start := time.Now()
+				for n := 0; n < repeats; n++ {
+					statedb.Copy()
+				}
+				return time.Since(start) / repeats
+			}
+			dirty := measure()
+			statedb.Finalise(true)
+			pending := measure()
+			cfg.printf("%8d %8d %14.1f %14.1f", objects, slots, float64(dirty.Nanoseconds())/1e3, float64(pending.Nanoseconds())/1e3)
+		}
+	}
+	return nil
+}
+
+// runCodeBlobsWorkload deploys one code blob per transaction onto a fresh
+// account, halving in size from the EIP-170 limit of 24KB down to 3 bytes,
+// and sets code table throughput against that of the trie. Code only reaches
+// disk inside the statedb commit, along with the trie nodes, so after the run
+// every blob is read back from the code table and written into it once more
+// on its own; the trie's share of the commit time is estimated as the rest.
+// Trie reads are timed as account lookups of the same accounts.
+func runCodeBlobsWorkload(env *benchEnv, cfg *workloadConfig) error {
+	type codeBlob struct {
+		addr common.Address
+		hash common.Hash
+		code []byte // read back from the code table
+	}
+	var (
+		buckets    = []int{256, 1024, 4096, 16384, params.MaxCodeSize}
+		blobs      = make([]codeBlob, cfg.txs)
+		codeBytes  int64
+		commitTime time.Duration
+	)
+	bucket := func(size int) int {
+		for b, limit := range buckets {
+			if size <= limit {
+				return b
+			}
+		}
+		return len(buckets) - 1
+	}
+	for i := range blobs {
+		code := make([]byte, params.MaxCodeSize>>(i%14))
+		cfg.rand.Read(code)
+		addr := common.BytesToAddress(crypto.Keccak256([]byte(fmt.Sprintf("code-blob-%d", i)))[:20])
+		env.statedb.SetNonce(addr, 1, tracing.NonceChangeUnspecified)
+		env.statedb.SetCode(addr, code)
+		blobs[i] = codeBlob{addr: addr, hash: crypto.Keccak256Hash(code)}
+		codeBytes += int64(len(code))
+
+		if cfg.endOfBlock(i) {
+			start := time.Now()
+			if err := env.endBlock(); err != nil {
+				return err
+			}
+			commitTime += time.Since(start)
+		}
+	}
+	start := time.Now()
+	if err := flushShards([]*benchEnv{env}, true); err != nil {
+		return err
+	}
+	commitTime += time.Since(start)
+
+	var (
+		db     = env.trieDB.Disk()
+		perm   = cfg.rand.Perm(len(blobs))
+		counts = make([]int, len(buckets))
+		sizes  = make([]int64, len(buckets))
+		reads  = make([]time.Duration, len(buckets))
+		writes = make([]time.Duration, len(buckets))
+	)
+	for _, i := range perm {
+		start := time.Now()
+		code := rawdb.ReadCode(db, blobs[i].hash)
+		elapsed := time.Since(start)
+		if len(code) == 0 {
+			return fmt.Errorf("code %x of %x missing", blobs[i].hash, blobs[i].addr)
+		}
+		b := bucket(len(code))
+		counts[b]++
+		sizes[b] += int64(len(code))
+		reads[b] += elapsed
+		blobs[i].code = code
+	}
+	var codeWrites time.Duration
+	for b := range buckets {
+		batch := db.NewBatch()
+		start = time.Now()
+		for _, blob := range blobs {
+			if bucket(len(blob.code)) != b {
+				continue
+			}
+			rawdb.WriteCode(batch, blob.hash, blob.code)
+			if batch.ValueSize() > ethdb.IdealBatchSize {
+				if err := batch.Write(); err != nil {
+					return err
+				}
+				batch.Reset()
+			}
+		}
+		if err := batch.Write(); err != nil {
+			return err
+		}
+		writes[b] = time.Since(start)
+		codeWrites += writes[b]
+	}
+	statedb, err := state.New(env.root, env.sdb)
+	if err != nil {
+		return err
+	}
+	start = time.Now()
+	for _, i := range perm {
+		statedb.GetCodeHash(blobs[i].addr)
+	}
+	lookupTime := time.Since(start)
+
+	mbps := func(n int64, d time.Duration) float64 {
+		if d == 0 {
+			return 0
+		}
+		return float64(n) / (1024 * 1024) / d.Seconds()
+	}
+	cfg.printf("Code Blobs:    %d accounts, %.2f MB of code", len(blobs), float64(codeBytes)/(1024*1024))
+	cfg.printf("Block Commit:  %v total, %v of it code writes as measured alone, %v trie (est.)",
+		commitTime, codeWrites, max(commitTime-codeWrites, 0))
+	cfg.printf("Trie Lookups:  %.1f µs per account", float64(lookupTime.Microseconds())/float64(max(len(blobs), 1)))
+	cfg.printf("%15s %8s %10s %12s %12s", "Size", "Blobs", "Code (MB)", "Write MB/s", "Read MB/s")
+	lower := 0
+	for b, limit := range buckets {
+		cfg.printf("%7d-%-7d %8d %10.2f %12.1f %12.1f", lower, limit, counts[b], float64(sizes[b])/(1024*1024), mbps(sizes[b], writes[b]), mbps(sizes[b], reads[b]))
+		lower = limit + 1
+	}
+	return nil
+}
+
+// runJournalWorkload stresses the statedb journal the way deeply nested
+// contract calls do: every transaction descends 32 call frames, taking a
+// snapshot at each and writing cfg.jwrites slots in between, then unwinds with
+// every other frame reverting. Journal growth is measured as the heap held
+// at the deepest point, and revert cost per reverted write.
+func runJournalWorkload(env *benchEnv, cfg *workloadConfig) error {
+	const depth = 32
+
+	var (
+		snapTimes, revertTimes []int // ns per Snapshot() and per write reverted
+		journalHeap            []int // heap bytes per journaled write
+		reverted, kept         int
+	)
+	for i := 0; i < cfg.txs; i++ {
+		var (
+			base  = liveHeap()
+			snaps = make([]int, depth)
+			addr  = cfg.addrs[cfg.rand.Intn(len(cfg.addrs))]
+		)
+		for d := 0; d < depth; d++ {
+			start := time.Now()
+			snaps[d] = env.statedb.Snapshot()
+			snapTimes = append(snapTimes, int(time.Since(start).Nanoseconds()))
+			for j := 0; j < cfg.jwrites; j++ {
+				val := crypto.Keccak256Hash([]byte(fmt.Sprintf("journal-%d-%d-%d", i, d, j)))
+				env.statedb.SetState(addr, cfg.slotKey(cfg.rand.Intn(cfg.slots)), val)
+			}
+		}
+		if held := liveHeap(); held > base {
+			journalHeap = append(journalHeap, int(held-base)/(depth*cfg.jwrites))
+		}
+		// Unwind with every odd frame failing, which also reverts whatever
+		// its children left in the journal
+		next := depth // first frame already reverted
+		for d := depth - 1; d >= 0; d-- {
+			if d%2 == 0 {
+				continue
+			}
+			start := time.Now()
+			env.statedb.RevertToSnapshot(snaps[d])
+			elapsed := time.Since(start)
+			undone := (next - d) * cfg.jwrites
+			revertTimes = append(revertTimes, int(elapsed.Nanoseconds())/max(undone, 1))
+			reverted += undone
+			next = d
+		}
+		kept += next * cfg.jwrites
+		env.statedb.Finalise(true)
+
+		if cfg.endOfBlock(i) {
+			if err := env.endBlock(); err != nil {
+				return err
+			}
+		}
+	}
+	cfg.printf("Transactions:  %d (depth %d, %d writes per frame)", cfg.txs, depth, cfg.jwrites)
+	cfg.printf("Snapshot():    %s ns", distribution(snapTimes))
+	cfg.printf("Revert:        %s ns per write", distribution(revertTimes))
+	cfg.printf("Journal Heap:  %s bytes per write", distribution(journalHeap))
+	cfg.printf("Frames:        %d writes reverted, %d kept", reverted, kept)
+	return nil
+}
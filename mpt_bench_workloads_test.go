@@ -0,0 +1,62 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Net gas metering cases of EIP-2200 with the EIP-2929 and EIP-3529 costs, for
+// a slot that is already warm.
+func TestSstoreGas(t *testing.T) {
+	var (
+		zero = common.Hash{}
+		x    = common.HexToHash("0x01")
+		y    = common.HexToHash("0x02")
+	)
+	tests := []struct {
+		name                     string
+		original, current, value common.Hash
+		gas                      uint64
+		refund                   int64
+	}{
+		{"no-op", x, x, x, 100, 0},
+		{"no-op on zero", zero, zero, zero, 100, 0},
+		{"fresh set", zero, zero, x, 20000, 0},
+		{"fresh update", x, x, y, 2900, 0},
+		{"fresh clear", x, x, zero, 2900, 4800},
+		{"dirty update", x, y, x, 100, 2800},
+		{"dirty update elsewhere", x, y, zero, 100, 4800},
+		{"dirty unclear", x, zero, y, 100, -4800},
+		{"dirty unclear to original", x, zero, x, 100, -4800 + 2800},
+		{"dirty set reverted", zero, x, zero, 100, 19900},
+		{"dirty set updated", zero, x, y, 100, 0},
+	}
+	for _, tt := range tests {
+		gas, refund := sstoreGas(tt.original, tt.current, tt.value)
+		if gas != tt.gas || refund != tt.refund {
+			t.Errorf("%s: have gas %d, refund %d, want %d, %d", tt.name, gas, refund, tt.gas, tt.refund)
+		}
+	}
+}
+
+// TestSystemContractsWrap checks that the history buffer is reported as
+// wrapping by the block numbers written, not by the blocks of the run.
+func TestSystemContractsWrap(t *testing.T) {
+	tests := []struct {
+		start string
+		want  string
+	}{
+		{"0", "while filling"},
+		{"8000", "while filling"},
+		{"9000", "after wrapping"},
+	}
+	for _, tt := range tests {
+		out := runBench(t, "-n", "10", "-slots", "2", "-m", "20", "-k", "5", "-workload", "system", "-start-block", tt.start, "-db", filepath.Join(t.TempDir(), "db"))
+		if !strings.Contains(out, "Disk Growth:") || !strings.Contains(out, tt.want) {
+			t.Errorf("start block %s: disk growth not reported %s:\n%s", tt.start, tt.want, out)
+		}
+	}
+}